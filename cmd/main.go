@@ -1,24 +1,55 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/internal/commands"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificaterequests"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates"
 	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates/signer"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/secrets"
+	"github.com/alpha-unito/k8s-vault-signer/internal/webhook"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/audit"
 	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/inventory"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/tracing"
 	vault "github.com/alpha-unito/k8s-vault-signer/pkg/vault/client"
-	"github.com/alpha-unito/k8s-vault-signer/pkg/vault/sign"
 	"github.com/alpha-unito/k8s-vault-signer/pkg/version"
+	"github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
+	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/component-base/cli"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 )
 
@@ -29,66 +60,339 @@ func main() {
 		Use:   "vault-signer",
 		Short: "Vault CSR signer for Kubernetes",
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := c.Load(cmd.Flags()); err != nil {
+				klog.Exitf("error loading configuration: %s", err)
+			}
 			if err := c.Validate(); err != nil {
 				klog.Exitf("error validating configuration: %s", err)
 			}
 
 			ctx, cancel := context.WithCancel(context.Background())
 
-			vclient, err := vault.NewClient(c.VaultAddress)
-			if err != nil {
-				klog.Exitf("error creating Vault client: %s", err)
+			if c.Tracing.Endpoint != "" {
+				shutdown, err := tracing.Setup(ctx, c.Tracing.Endpoint, c.Tracing.SamplingRatio)
+				if err != nil {
+					klog.Exitf("error setting up tracing: %s", err)
+				}
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := shutdown(ctx); err != nil {
+						klog.Errorf("error flushing traces: %s", err)
+					}
+				}()
 			}
 
-			authenticator, err := vault.NewAuthenticator(c.VaultAuthConfig)
+			cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
 			if err != nil {
-				klog.Exitf("error creating Vault authenticator: %s", err)
+				klog.Exitf("error building kubernetes config from flags: %s", err)
 			}
 
-			secret, err := authenticator.Authenticate(ctx, vclient)
+			kclient, err := kubernetes.NewForConfig(cfg)
 			if err != nil {
-				klog.Exitf("error authenticating with Vault: %s", err)
+				klog.Exitf("error creating kubernetes client from config: %s", err)
 			}
 
-			watcher, err := vault.NewWatcher(authenticator, vclient, secret)
-			if err != nil {
-				klog.Exitf("error creating token watcher: %s", err)
+			// Vault is not needed when all the signers are backed by a local CA
+			var vclient *api.Client
+			var watcher *vault.Watcher
+			if c.UsesVault() {
+				vclient, err = vault.NewClient(c.Vault.Address, c.Tracing.Endpoint != "")
+				if err != nil {
+					klog.Exitf("error creating Vault client: %s", err)
+				}
+
+				authenticator, err := c.Authenticator()
+				if err != nil {
+					klog.Exitf("error creating Vault authenticator: %s", err)
+				}
+
+				secret, err := authenticator.Authenticate(ctx, vclient)
+				if err != nil {
+					klog.Exitf("error authenticating with Vault: %s", err)
+				}
+
+				watcher, err = vault.NewWatcher(authenticator, vclient, secret)
+				if err != nil {
+					klog.Exitf("error creating token watcher: %s", err)
+				}
 			}
 
-			cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
+			factory := informers.NewSharedInformerFactory(kclient, c.Controller.ResyncPeriod.Duration)
+			csrInformers, csrFactories := newCSRInformers(kclient, factory, c)
+			rateLimiter := func() workqueue.RateLimiter {
+				return certificates.NewRateLimiter(certificates.RateLimiterOptions{
+					BaseDelay: c.Controller.RateLimiter.BaseDelay.Duration,
+					MaxDelay:  c.Controller.RateLimiter.MaxDelay.Duration,
+					QPS:       c.Controller.RateLimiter.QPS,
+					Burst:     c.Controller.RateLimiter.Burst,
+				})
+			}
+
+			// the CSRs created for VaultCertificateRequests are recognized by the identity of the controller
+			var requestController string
+			if c.CertificateRequests.Enabled {
+				review, err := kclient.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+				if err != nil {
+					klog.Exitf("error retrieving the controller identity: %s", err)
+				}
+				requestController = review.Status.UserInfo.Username
+			}
+
+			limiters := signing.NewLimiters()
+			signerOptions, err := newSigners(ctx, vclient, kclient, limiters, c, requestController)
 			if err != nil {
-				klog.Exitf("error building kubernetes config from flags: %s", err)
+				klog.Exitf("error creating signers: %s", err)
 			}
 
-			kclient, err := kubernetes.NewForConfig(cfg)
+			policies, err := c.Policies()
 			if err != nil {
-				klog.Exitf("error creating kubernetes client from config: %s", err)
+				klog.Exitf("error loading signing policy: %s", err)
 			}
 
-			factory := informers.NewSharedInformerFactory(kclient, 5*time.Minute)
-			csrInformer := factory.Certificates().V1().CertificateSigningRequests()
+			// the sink outlives ctx, so that the syncs completing during the shutdown are notified, and is
+			// stopped by Close
+			sink, err := newAuditSink(context.WithoutCancel(ctx), c)
+			if err != nil {
+				klog.Exitf("error creating audit sink: %s", err)
+			}
+			if sink != nil {
+				defer func() { _ = sink.Close() }()
+			}
 
-			vaultSigner, err := sign.NewSigner(vclient, c.VaultPki, c.VaultRole)
+			issuanceCache, err := signer.NewIssuanceCache(c.Controller.IssuanceCache.TTL.Duration, c.Controller.IssuanceCache.Path)
 			if err != nil {
-				klog.Exitf("error creating Vault signer: %s", err)
+				klog.Exitf("error loading issuance cache: %s", err)
 			}
 
+			var windows []time.Duration
+			for _, d := range c.Inventory.ExpiringWithin {
+				windows = append(windows, d.Duration)
+			}
+			inv := inventory.New(windows)
+
 			controller, err := signer.NewVaultCSRSigningController(
 				ctx,
 				kclient,
-				csrInformer,
-				vaultSigner,
-				c.SigningDuration.Duration,
+				uniqueInformers(csrInformers),
+				signerOptions,
+				policies,
+				sink,
+				issuanceCache,
+				inv,
+				rateLimiter(),
 			)
 			if err != nil {
 				klog.Fatalf("error creating auth signing controller: %s", err)
 			}
 
-			go csrInformer.Informer().Run(ctx.Done())
-			go controller.Run(ctx, 5)
-			go watcher.Watch(ctx, vclient)
+			runners := []func(context.Context){
+				func(ctx context.Context) {
+					controller.Run(ctx, c.Controller.Workers, c.Controller.ShutdownGracePeriod.Duration)
+				},
+			}
+
+			if c.CertificateRequests.Enabled {
+				dclient, err := dynamic.NewForConfig(cfg)
+				if err != nil {
+					klog.Exitf("error creating dynamic kubernetes client from config: %s", err)
+				}
+
+				dfactory := dynamicinformer.NewDynamicSharedInformerFactory(dclient, c.Controller.ResyncPeriod.Duration)
+				requestController := certificaterequests.NewCertificateRequestController(
+					ctx,
+					kclient,
+					dclient,
+					dfactory.ForResource(v1alpha1.VaultCertificateRequestGVR),
+					csrInformers[c.CertificateRequests.SignerName],
+					factory.Core().V1().Namespaces(),
+					c.CertificateRequests.SignerName,
+					trustDomain(c, c.CertificateRequests.SignerName),
+					rateLimiter(),
+				)
+
+				dfactory.Start(ctx.Done())
+				runners = append(runners, func(ctx context.Context) {
+					requestController.Run(ctx, c.Controller.Workers, c.Controller.ShutdownGracePeriod.Duration)
+				})
+			}
+
+			var secretController *secrets.SecretController
+			if c.ManagedSecrets.Enabled {
+				sfactory := informers.NewSharedInformerFactoryWithOptions(kclient, c.Controller.ResyncPeriod.Duration,
+					informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+						options.LabelSelector = v1alpha1.ManagedCertificateLabel + "=true"
+					}),
+				)
+				secretController = secrets.NewSecretController(
+					ctx,
+					kclient,
+					sfactory.Core().V1().Secrets(),
+					factory.Core().V1().Namespaces(),
+					controller,
+					c.ManagedSecrets.SignerName,
+					trustDomain(c, c.ManagedSecrets.SignerName),
+					c.ManagedSecrets.RenewalFraction,
+					rateLimiter(),
+				)
+
+				sfactory.Start(ctx.Done())
+				runners = append(runners, func(ctx context.Context) {
+					secretController.Run(ctx, c.Controller.Workers, c.Controller.ShutdownGracePeriod.Duration)
+				})
+			}
+
+			var injector *webhook.PodInjector
+			if c.Webhook.Enabled {
+				injector = webhook.NewPodInjector(c.Webhook.Image, c.Webhook.SignerName, trustDomain(c, c.Webhook.SignerName), spiffeTTL(c, c.Webhook.SignerName))
+				server := webhook.NewServer(
+					c.Webhook.Address,
+					c.Webhook.CertFile,
+					c.Webhook.KeyFile,
+					injector,
+				)
+				go func() {
+					if err := server.Run(ctx); err != nil {
+						klog.Errorf("error running admission webhook: %s", err)
+						cancel()
+					}
+				}()
+			}
+
+			if c.Metrics.Address != "" {
+				go func() {
+					if err := runMetricsServer(ctx, c.Metrics.Address, inv); err != nil {
+						klog.Errorf("error running metrics server: %s", err)
+						cancel()
+					}
+				}()
+			}
+
+			factory.Start(ctx.Done())
+			for _, f := range csrFactories {
+				f.Start(ctx.Done())
+			}
+			go scanInventory(ctx, inv, uniqueInformers(csrInformers), signerNames(c))
+			go inv.Run(ctx, time.Minute)
+
+			// the token is renewed until the in-flight syncs complete
+			watchCtx, stopWatch := context.WithCancel(context.Background())
+			watchDone := make(chan struct{})
+			if watcher != nil {
+				go func() {
+					watcher.Watch(watchCtx, vclient)
+					close(watchDone)
+				}()
+			} else {
+				close(watchDone)
+			}
+
+			// the informers and the controllers are created from the startup configuration, and the settings
+			// that cannot be changed afterwards are compared with the last applied configuration
+			applied := c
+			reloader := config.NewReloader(c, cmd.Flags(), func(next *config.Config) error {
+				if next.UsesVault() && watcher == nil {
+					return fmt.Errorf("enabling Vault signers requires a restart")
+				}
+				if next.Controller.WatchAllSigners != applied.Controller.WatchAllSigners {
+					return fmt.Errorf("changing watchAllSigners requires a restart, as the informers are created at startup")
+				}
+				if !applied.Controller.WatchAllSigners && !slices.Equal(signerNames(applied), signerNames(next)) {
+					return fmt.Errorf("changing the signer names requires a restart, as the informers only watch the CSRs of the configured signers")
+				}
+				if c.CertificateRequests.Enabled && next.CertificateRequests.SignerName != applied.CertificateRequests.SignerName {
+					return fmt.Errorf("changing the signer of certificate requests requires a restart, as the informers only watch its CSRs")
+				}
+				if changed := restartRequired(applied, next); len(changed) > 0 {
+					return fmt.Errorf("changing %s requires a restart", strings.Join(changed, ", "))
+				}
+				// new credentials are tried before creating the signers, which read the Vault roles with them
+				if watcher != nil && next.UsesVault() {
+					authenticator, err := next.Authenticator()
+					if err != nil {
+						return err
+					}
+					if !authenticator.Equal(watcher.Authenticator()) {
+						if err := watcher.Login(ctx, vclient, authenticator); err != nil {
+							return fmt.Errorf("unable to log into Vault with the new credentials: %v", err)
+						}
+					}
+				}
+				signerOptions, err := newSigners(ctx, vclient, kclient, limiters, next, requestController)
+				if err != nil {
+					return err
+				}
+				policies, err := next.Policies()
+				if err != nil {
+					return err
+				}
+				if err := controller.UpdateSigners(signerOptions, policies); err != nil {
+					return err
+				}
+				if secretController != nil {
+					secretController.Update(next.ManagedSecrets.SignerName, trustDomain(next, next.ManagedSecrets.SignerName), next.ManagedSecrets.RenewalFraction)
+				}
+				if injector != nil {
+					injector.Update(next.Webhook.Image, next.Webhook.SignerName, trustDomain(next, next.Webhook.SignerName), spiffeTTL(next, next.Webhook.SignerName))
+				}
+				applied = next
+				return nil
+			})
+			go reloader.Run(ctx)
+
+			// controllers are not started once the shutdown has begun, so that no Add races with the Wait
+			var running sync.WaitGroup
+			var runningLock sync.Mutex
+			shuttingDown := false
+			runControllers := func(ctx context.Context) {
+				runningLock.Lock()
+				defer runningLock.Unlock()
+				if shuttingDown {
+					return
+				}
+				running.Add(len(runners))
+				for _, run := range runners {
+					go func() {
+						defer running.Done()
+						run(ctx)
+					}()
+				}
+			}
 
-			sigterm := make(chan os.Signal)
+			// the lease is released only after the controllers are drained, so that the next leader does not
+			// sign the CSRs being processed
+			leCtx, releaseLease := context.WithCancel(context.Background())
+			leDone := make(chan struct{})
+			if c.Controller.LeaderElection.Enabled {
+				elector, err := newLeaderElector(kclient, c, leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(leading context.Context) {
+						klog.Info("acquired leader lease, starting controllers")
+						runCtx, stop := context.WithCancel(ctx)
+						go func() {
+							<-leading.Done()
+							stop()
+						}()
+						runControllers(runCtx)
+					},
+					OnStoppedLeading: func() {
+						if ctx.Err() == nil {
+							klog.Exitf("lost leader lease")
+						}
+					},
+				})
+				if err != nil {
+					klog.Exitf("error creating leader elector: %s", err)
+				}
+				go func() {
+					elector.Run(leCtx)
+					close(leDone)
+				}()
+			} else {
+				runControllers(ctx)
+				close(leDone)
+			}
+
+			sigterm := make(chan os.Signal, 1)
 			signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 
 			select {
@@ -98,13 +402,275 @@ func main() {
 				klog.Info("certificate controller terminated correctly")
 			}
 
+			// stop accepting new items and wait for the in-flight syncs
+			runningLock.Lock()
+			shuttingDown = true
+			runningLock.Unlock()
 			cancel()
+			drained := make(chan struct{})
+			go func() {
+				running.Wait()
+				close(drained)
+			}()
+			select {
+			case <-drained:
+				klog.Info("in-flight syncs completed")
+			case <-time.After(c.Controller.ShutdownGracePeriod.Duration):
+				klog.Warningf("shutdown grace period of %s expired with syncs in flight", c.Controller.ShutdownGracePeriod.Duration)
+			}
+
+			releaseLease()
+			<-leDone
+
+			stopWatch()
+			<-watchDone
+
+			if vclient != nil && c.Vault.RevokeTokenOnExit {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := vclient.Auth().Token().RevokeSelfWithContext(ctx, ""); err != nil {
+					klog.Errorf("error revoking Vault token: %s", err)
+				} else {
+					klog.Info("revoked Vault token")
+				}
+			}
 		},
 		Version: version.Version,
 	}
 
+	cmd.AddCommand(commands.NewRequestCommand(c))
+	cmd.AddCommand(commands.NewValidateConfigCommand(c))
+	cmd.AddCommand(commands.NewCheckCommand(c))
+	cmd.AddCommand(commands.NewSignCommand(c))
+	cmd.AddCommand(commands.NewInspectCommand(c))
 	c.AddFlags(pflag.CommandLine)
 
 	code := cli.Run(cmd)
 	os.Exit(code)
 }
+
+func newSigners(ctx context.Context, vclient *api.Client, kclient kubernetes.Interface, limiters *signing.Limiters, c *config.Config, requestController string) ([]signer.SignerOptions, error) {
+	var signerOptions []signer.SignerOptions
+	for _, s := range c.Signers {
+		sgnr, err := limiters.NewSigner(ctx, vclient, kclient, s)
+		if err != nil {
+			return nil, fmt.Errorf("error creating signer %s: %v", s.Name, err)
+		}
+		opts := signer.SignerOptionsFromConfig(s, sgnr)
+		if c.CertificateRequests.Enabled && s.Name == c.CertificateRequests.SignerName {
+			opts.RequestController = requestController
+		}
+		signerOptions = append(signerOptions, opts)
+	}
+	return signerOptions, nil
+}
+
+// restartRequired returns the settings changed by the next configuration that are only applied at startup
+func restartRequired(applied, next *config.Config) []string {
+	webhookServer := func(w config.WebhookConfig) config.WebhookConfig {
+		return config.WebhookConfig{Enabled: w.Enabled, Address: w.Address, CertFile: w.CertFile, KeyFile: w.KeyFile}
+	}
+	settings := []struct {
+		name  string
+		equal bool
+	}{
+		{"kubeconfig", applied.Kubeconfig == next.Kubeconfig},
+		{"vault.address", applied.Vault.Address == next.Vault.Address},
+		{"vault.revokeTokenOnExit", applied.Vault.RevokeTokenOnExit == next.Vault.RevokeTokenOnExit},
+		{"controller.workers", applied.Controller.Workers == next.Controller.Workers},
+		{"controller.resyncPeriod", applied.Controller.ResyncPeriod == next.Controller.ResyncPeriod},
+		{"controller.reloadPeriod", applied.Controller.ReloadPeriod == next.Controller.ReloadPeriod},
+		{"controller.rateLimiter", applied.Controller.RateLimiter == next.Controller.RateLimiter},
+		{"controller.shutdownGracePeriod", applied.Controller.ShutdownGracePeriod == next.Controller.ShutdownGracePeriod},
+		{"controller.leaderElection", applied.Controller.LeaderElection == next.Controller.LeaderElection},
+		{"controller.issuanceCache", applied.Controller.IssuanceCache == next.Controller.IssuanceCache},
+		{"certificateRequests.enabled", applied.CertificateRequests.Enabled == next.CertificateRequests.Enabled},
+		{"managedSecrets.enabled", applied.ManagedSecrets.Enabled == next.ManagedSecrets.Enabled},
+		{"webhook", webhookServer(applied.Webhook) == webhookServer(next.Webhook)},
+		{"audit", reflect.DeepEqual(applied.Audit, next.Audit)},
+		{"tracing", applied.Tracing == next.Tracing},
+		{"metrics", applied.Metrics == next.Metrics},
+		{"inventory", reflect.DeepEqual(applied.Inventory, next.Inventory)},
+	}
+	var changed []string
+	for _, setting := range settings {
+		if !setting.equal {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// trustDomain returns the SPIFFE trust domain of the signer, if any
+func trustDomain(c *config.Config, signerName string) string {
+	if s := c.Signer(signerName); s != nil && s.SPIFFE != nil {
+		return s.SPIFFE.TrustDomain
+	}
+	return ""
+}
+
+// spiffeTTL returns the maximum duration of the SPIFFE certificates of the signer, if any
+func spiffeTTL(c *config.Config, signerName string) time.Duration {
+	if s := c.Signer(signerName); s != nil && s.SPIFFE != nil {
+		return s.SPIFFE.MaxTTL.Duration
+	}
+	return 0
+}
+
+// newAuditSink combines the audit log and the webhooks. The log comes first, so that records are notified
+// only once they are stored
+func newAuditSink(ctx context.Context, c *config.Config) (audit.Sink, error) {
+	var sinks audit.MultiSink
+	sink, err := audit.NewSink(c.Audit.Path)
+	if err != nil {
+		return nil, err
+	}
+	if sink != nil {
+		sinks = append(sinks, sink)
+	}
+
+	for _, w := range c.Audit.Webhooks {
+		opts := audit.WebhookOptions{
+			URL: w.URL,
+			Filter: audit.Filter{
+				Signers:         w.Signers,
+				Profiles:        w.Profiles,
+				Reasons:         w.Reasons,
+				MinRejections:   w.MinRejections,
+				RejectionWindow: w.RejectionWindow.Duration,
+			},
+			Timeout:    w.Timeout.Duration,
+			MaxRetries: w.MaxRetries,
+			QueueSize:  w.QueueSize,
+		}
+		for _, outcome := range w.Outcomes {
+			opts.Filter.Outcomes = append(opts.Filter.Outcomes, audit.Outcome(outcome))
+		}
+		if w.SecretFile != "" {
+			secret, err := os.ReadFile(w.SecretFile)
+			if err != nil {
+				_ = sinks.Close()
+				return nil, fmt.Errorf("unable to read webhook secret: %v", err)
+			}
+			opts.Secret = bytes.TrimSpace(secret)
+		}
+		sinks = append(sinks, audit.NewWebhookSink(ctx, opts))
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
+// newCSRInformers returns the informer of the CSRs of each signer. Unless all signers are watched, each signer
+// has its own informer filtered by spec.signerName, started by the returned factories
+func newCSRInformers(kclient kubernetes.Interface, factory informers.SharedInformerFactory, c *config.Config) (map[string]certificatesinformers.CertificateSigningRequestInformer, []informers.SharedInformerFactory) {
+	csrInformers := make(map[string]certificatesinformers.CertificateSigningRequestInformer, len(c.Signers))
+	var factories []informers.SharedInformerFactory
+	for _, s := range c.Signers {
+		if c.Controller.WatchAllSigners {
+			csrInformers[s.Name] = factory.Certificates().V1().CertificateSigningRequests()
+			continue
+		}
+		selector := fields.OneTermEqualSelector("spec.signerName", s.Name).String()
+		f := informers.NewSharedInformerFactoryWithOptions(kclient, c.Controller.ResyncPeriod.Duration,
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = selector
+			}),
+		)
+		factories = append(factories, f)
+		csrInformers[s.Name] = f.Certificates().V1().CertificateSigningRequests()
+	}
+	return csrInformers, factories
+}
+
+func uniqueInformers(csrInformers map[string]certificatesinformers.CertificateSigningRequestInformer) []certificatesinformers.CertificateSigningRequestInformer {
+	var unique []certificatesinformers.CertificateSigningRequestInformer
+	seen := sets.New[cache.SharedIndexInformer]()
+	for _, name := range sets.List(sets.KeySet(csrInformers)) {
+		if informer := csrInformers[name]; !seen.Has(informer.Informer()) {
+			seen.Insert(informer.Informer())
+			unique = append(unique, informer)
+		}
+	}
+	return unique
+}
+
+func signerNames(c *config.Config) []string {
+	names := make([]string, 0, len(c.Signers))
+	for _, s := range c.Signers {
+		names = append(names, s.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// scanInventory adds the certificates of the existing CSRs of the signers to the inventory, once the informers
+// are synced
+func scanInventory(ctx context.Context, inv *inventory.Inventory, csrInformers []certificatesinformers.CertificateSigningRequestInformer, names []string) {
+	for _, informer := range csrInformers {
+		if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+			return
+		}
+		csrs, err := informer.Lister().List(labels.Everything())
+		if err != nil {
+			klog.Errorf("error listing CSRs for inventory: %s", err)
+			continue
+		}
+		for _, csr := range csrs {
+			if !slices.Contains(names, csr.Spec.SignerName) {
+				continue
+			}
+			if err := inv.Add(csr); err != nil {
+				klog.Warningf("error adding certificate to inventory: %s", err)
+			}
+		}
+	}
+}
+
+// runMetricsServer serves the Prometheus metrics and the certificate inventory until the context is done
+func runMetricsServer(ctx context.Context, address string, inv *inventory.Inventory) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	mux.Handle("/inventory", inv)
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// newLeaderElector creates the elector of the replica running the controllers, identified by its hostname
+func newLeaderElector(kclient kubernetes.Interface, c *config.Config, callbacks leaderelection.LeaderCallbacks) (*leaderelection.LeaderElector, error) {
+	le := c.Controller.LeaderElection
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get hostname: %v", err)
+	}
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		le.LeaseNamespace,
+		le.LeaseName,
+		kclient.CoreV1(),
+		kclient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: hostname + "_" + string(uuid.NewUUID())},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   le.LeaseDuration.Duration,
+		RenewDeadline:   le.RenewDeadline.Duration,
+		RetryPeriod:     le.RetryPeriod.Duration,
+		Callbacks:       callbacks,
+		ReleaseOnCancel: true,
+		Name:            le.LeaseName,
+	})
+}
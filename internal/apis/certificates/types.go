@@ -2,4 +2,6 @@ package certificates
 
 const (
 	VaultSignerName = "unito.it/vault-signer"
+
+	ServiceAccountUsernamePrefix = "system:serviceaccount:"
 )
@@ -0,0 +1,26 @@
+package v1alpha1
+
+const (
+	// ManagedCertificateLabel selects the Secrets whose certificate is managed by the Vault signer
+	ManagedCertificateLabel = GroupName + "/managed-certificate"
+
+	// Annotations describing the certificate requested by managed Secrets and injected Pods
+	CommonNameAnnotation          = GroupName + "/common-name"
+	OrganizationsAnnotation       = GroupName + "/organizations"
+	DNSNamesAnnotation            = GroupName + "/dns-names"
+	IPAddressesAnnotation         = GroupName + "/ip-addresses"
+	URIsAnnotation                = GroupName + "/uris"
+	UsagesAnnotation              = GroupName + "/usages"
+	DurationAnnotation            = GroupName + "/duration"
+	RenewalFractionAnnotation     = GroupName + "/renewal-fraction"
+	PrivateKeyAlgorithmAnnotation = GroupName + "/private-key-algorithm"
+
+	// InjectAnnotation requests the injection of a certificate in a Pod
+	InjectAnnotation = GroupName + "/inject"
+
+	// ProfileAnnotation selects the signer profile a CSR must comply with
+	ProfileAnnotation = GroupName + "/profile"
+
+	// Annotation recording the hash of the specification of the issued certificate
+	IssuedSpecHashAnnotation = GroupName + "/issued-spec-hash"
+)
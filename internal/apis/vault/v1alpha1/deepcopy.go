@@ -0,0 +1,28 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func (in *VaultCertificateRequestStatus) DeepCopyInto(out *VaultCertificateRequestStatus) {
+	*out = *in
+	if in.Certificate != nil {
+		out.Certificate = make([]byte, len(in.Certificate))
+		copy(out.Certificate, in.Certificate)
+	}
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+}
+
+func (in *VaultCertificateRequestStatus) DeepCopy() *VaultCertificateRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultCertificateRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "vault.unito.it"
+	Version   = "v1alpha1"
+
+	VaultCertificateRequestKind     = "VaultCertificateRequest"
+	VaultCertificateRequestResource = "vaultcertificaterequests"
+)
+
+var VaultCertificateRequestGVR = schema.GroupVersionResource{
+	Group:    GroupName,
+	Version:  Version,
+	Resource: VaultCertificateRequestResource,
+}
+
+const (
+	// Labels and annotations set on the CSRs created on behalf of a VaultCertificateRequest
+	RequestNamespaceLabel = GroupName + "/request-namespace"
+	RequestNameLabel      = GroupName + "/request-name"
+	RequesterAnnotation   = GroupName + "/requester"
+)
+
+const (
+	ConditionReady = "Ready"
+
+	ReasonPending = "Pending"
+	ReasonInvalid = "Invalid"
+	ReasonDenied  = "Denied"
+	ReasonFailed  = "Failed"
+	ReasonIssued  = "Issued"
+)
+
+type VaultCertificateRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultCertificateRequestSpec   `json:"spec"`
+	Status VaultCertificateRequestStatus `json:"status,omitempty"`
+}
+
+type VaultCertificateRequestSpec struct {
+	// PEM-encoded PKCS#10 certificate request
+	Request           []byte          `json:"request"`
+	Usages            []capi.KeyUsage `json:"usages,omitempty"`
+	ExpirationSeconds *int32          `json:"expirationSeconds,omitempty"`
+	SecretName        string          `json:"secretName,omitempty"`
+}
+
+type VaultCertificateRequestStatus struct {
+	CertificateSigningRequestName string             `json:"certificateSigningRequestName,omitempty"`
+	Certificate                   []byte             `json:"certificate,omitempty"`
+	Conditions                    []metav1.Condition `json:"conditions,omitempty"`
+}
@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+	vault "github.com/alpha-unito/k8s-vault-signer/pkg/vault/client"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/vault/sign"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type checkOptions struct {
+	as string
+}
+
+type checkResult struct {
+	name   string
+	err    error
+	detail string
+}
+
+// NewCheckCommand creates a command that verifies the Vault and Kubernetes setup required to sign CSRs
+func NewCheckCommand(c *config.Config) *cobra.Command {
+	o := &checkOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Run preflight checks against Vault and Kubernetes and print a pass/fail report",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Load(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := c.Validate(); err != nil {
+				return fmt.Errorf("invalid configuration: %v", err)
+			}
+			return o.run(cmd.Context(), cmd.OutOrStdout(), c)
+		},
+	}
+
+	cmd.Flags().StringVar(&o.as, "as", o.as, "Username to impersonate for the Kubernetes RBAC checks, e.g., the ServiceAccount of the signer (system:serviceaccount:<namespace>:<name>).")
+
+	return cmd
+}
+
+func (o *checkOptions) run(ctx context.Context, out io.Writer, c *config.Config) error {
+	var results []checkResult
+	kclient, err := o.kubernetesClient(c)
+	if err != nil {
+		results = append(results, checkResult{name: "kubernetes client", err: err})
+	}
+	results = append(results, checkSigners(ctx, c, kclient)...)
+	if kclient != nil {
+		results = append(results, checkRBAC(ctx, c, kclient)...)
+	}
+
+	failed := 0
+	for _, r := range results {
+		status := "PASS"
+		detail := r.detail
+		if r.err != nil {
+			status = "FAIL"
+			detail = r.err.Error()
+			failed++
+		}
+		fmt.Fprintf(out, "[%s] %s", status, r.name)
+		if detail != "" {
+			fmt.Fprintf(out, ": %s", detail)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	fmt.Fprintf(out, "all %d checks passed\n", len(results))
+	return nil
+}
+
+func (o *checkOptions) kubernetesClient(c *config.Config) (kubernetes.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if o.as != "" {
+		cfg.Impersonate.UserName = o.as
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+func checkSigners(ctx context.Context, c *config.Config, kclient kubernetes.Interface) []checkResult {
+	var results []checkResult
+	for _, s := range c.Signers {
+		if s.Local != nil {
+			_, err := signing.NewSigner(ctx, nil, kclient, s)
+			results = append(results, checkResult{name: fmt.Sprintf("local CA of %s", s.Name), err: err})
+		}
+	}
+	if !c.UsesVault() {
+		return results
+	}
+
+	vclient, err := vault.NewClient(c.Vault.Address, false)
+	if err != nil {
+		return append(results, checkResult{name: "vault client", err: err})
+	}
+
+	authenticator, err := c.Authenticator()
+	if err != nil {
+		return append(results, checkResult{name: "vault authentication", err: err})
+	}
+	if _, err := authenticator.Authenticate(ctx, vclient); err != nil {
+		return append(results, checkResult{name: "vault authentication", err: err})
+	}
+	results = append(results, checkResult{name: "vault authentication", detail: fmt.Sprintf("logged into %s", c.Vault.Address)})
+
+	for _, s := range c.Signers {
+		if s.Local != nil {
+			continue
+		}
+		rolePath := fmt.Sprintf("%s/roles/%s", s.Pki, s.Role)
+		if vsigner, err := sign.NewSigner(vclient, s.Pki, s.Role); err != nil {
+			results = append(results, checkResult{name: fmt.Sprintf("vault role %s", rolePath), err: err})
+		} else {
+			r := checkResult{
+				name:   fmt.Sprintf("vault role %s", rolePath),
+				detail: fmt.Sprintf("usages %s, max TTL %s", strings.Join(vsigner.Usages(), ","), vsigner.MaxTTL()),
+			}
+			if vsigner.MaxTTL() < s.SigningDuration.Duration {
+				r.detail += fmt.Sprintf(" (shorter than the signing duration %s of %s)", s.SigningDuration.Duration, s.Name)
+			}
+			results = append(results, r)
+		}
+
+		signPath := fmt.Sprintf("%s/sign/%s", s.Pki, s.Role)
+		results = append(results, checkResult{
+			name: fmt.Sprintf("vault capability update on %s", signPath),
+			err:  checkCapability(vclient, signPath, "update"),
+		})
+	}
+
+	return results
+}
+
+func checkCapability(vclient *api.Client, path, capability string) error {
+	capabilities, err := vclient.Sys().CapabilitiesSelf(path)
+	if err != nil {
+		return fmt.Errorf("unable to read capabilities: %v", err)
+	}
+	if !slices.Contains(capabilities, capability) && !slices.Contains(capabilities, "root") {
+		return fmt.Errorf("missing capability %s, got %v", capability, capabilities)
+	}
+	return nil
+}
+
+func checkRBAC(ctx context.Context, c *config.Config, kclient kubernetes.Interface) []checkResult {
+	attributes := []authorizationv1.ResourceAttributes{
+		{Group: capi.GroupName, Resource: "certificatesigningrequests", Verb: "list"},
+		{Group: capi.GroupName, Resource: "certificatesigningrequests", Verb: "watch"},
+		{Group: capi.GroupName, Resource: "certificatesigningrequests", Subresource: "status", Verb: "update"},
+	}
+	for _, s := range c.Signers {
+		attributes = append(attributes, authorizationv1.ResourceAttributes{
+			Group: capi.GroupName, Resource: "signers", Name: s.Name, Verb: "sign",
+		})
+		if s.Local != nil && s.Local.SecretName != "" {
+			attributes = append(attributes, authorizationv1.ResourceAttributes{
+				Namespace: s.Local.SecretNamespace, Resource: "secrets", Name: s.Local.SecretName, Verb: "get",
+			})
+		}
+	}
+
+	var results []checkResult
+	for _, attrs := range attributes {
+		results = append(results, checkResult{
+			name: fmt.Sprintf("kubernetes rbac %s", describeAttributes(attrs)),
+			err:  checkAccess(ctx, kclient, attrs),
+		})
+	}
+	return results
+}
+
+func checkAccess(ctx context.Context, kclient kubernetes.Interface, attrs authorizationv1.ResourceAttributes) error {
+	review, err := kclient.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attrs},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to create SelfSubjectAccessReview: %v", err)
+	}
+	if !review.Status.Allowed {
+		if review.Status.Reason != "" {
+			return fmt.Errorf("not allowed: %s", review.Status.Reason)
+		}
+		return fmt.Errorf("not allowed")
+	}
+	return nil
+}
+
+func describeAttributes(attrs authorizationv1.ResourceAttributes) string {
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource += "/" + attrs.Subresource
+	}
+	description := attrs.Verb + " " + resource
+	if attrs.Group != "" {
+		description += "." + attrs.Group
+	}
+	if attrs.Name != "" {
+		description += " " + attrs.Name
+	}
+	if attrs.Namespace != "" {
+		description += " in " + attrs.Namespace
+	}
+	return description
+}
@@ -0,0 +1,229 @@
+package commands
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates/signer"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	"github.com/spf13/cobra"
+
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type inspectOptions struct {
+	output    string
+	skipVault bool
+}
+
+type csrReport struct {
+	Name              string             `json:"name"`
+	SignerName        string             `json:"signerName"`
+	Username          string             `json:"username"`
+	Subject           string             `json:"subject,omitempty"`
+	DNSNames          []string           `json:"dnsNames,omitempty"`
+	IPAddresses       []string           `json:"ipAddresses,omitempty"`
+	URIs              []string           `json:"uris,omitempty"`
+	EmailAddresses    []string           `json:"emailAddresses,omitempty"`
+	Usages            []capi.KeyUsage    `json:"usages,omitempty"`
+	ExpirationSeconds *int32             `json:"expirationSeconds,omitempty"`
+	Conditions        []conditionReport  `json:"conditions,omitempty"`
+	WouldSign         bool               `json:"wouldSign"`
+	CheckError        string             `json:"checkError,omitempty"`
+	Certificate       *certificateReport `json:"certificate,omitempty"`
+}
+
+type conditionReport struct {
+	Type    capi.RequestConditionType `json:"type"`
+	Reason  string                    `json:"reason,omitempty"`
+	Message string                    `json:"message,omitempty"`
+}
+
+type certificateReport struct {
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Issuer    string    `json:"issuer"`
+}
+
+// NewInspectCommand creates a command that lists the CSRs of the configured signers, decoding the
+// requests and the issued certificates, and reporting whether they would be signed
+func NewInspectCommand(c *config.Config) *cobra.Command {
+	o := &inspectOptions{output: "table"}
+
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "List and decode the CSRs of the configured signers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Load(cmd.Flags()); err != nil {
+				return err
+			}
+			if o.output != "table" && o.output != "json" {
+				return fmt.Errorf("unsupported output format %q, must be table or json", o.output)
+			}
+			return o.run(cmd.Context(), cmd.OutOrStdout(), c)
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format, either table or json.")
+	cmd.Flags().BoolVar(&o.skipVault, "skip-vault", o.skipVault, "Do not create the signers, skipping the checks against the constraints of the Vault roles and local CAs.")
+
+	return cmd
+}
+
+func (o *inspectOptions) run(ctx context.Context, out io.Writer, c *config.Config) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes config from flags: %s", err)
+	}
+	kclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error creating kubernetes client from config: %s", err)
+	}
+
+	signers, err := o.signerOptions(ctx, c)
+	if err != nil {
+		return err
+	}
+	policies, err := c.Policies()
+	if err != nil {
+		return fmt.Errorf("error loading signing policy: %v", err)
+	}
+
+	var reports []csrReport
+	for _, opts := range signers {
+		csrs, err := kclient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("spec.signerName", opts.Name).String(),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to list csrs for signer %s: %v", opts.Name, err)
+		}
+		for i := range csrs.Items {
+			reports = append(reports, inspectCSR(&csrs.Items[i], opts, policies[opts.Name]))
+		}
+	}
+
+	if o.output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+	return printReports(out, reports)
+}
+
+func (o *inspectOptions) signerOptions(ctx context.Context, c *config.Config) ([]signer.SignerOptions, error) {
+	var signers []signer.SignerOptions
+	if o.skipVault {
+		for _, s := range c.Signers {
+			signers = append(signers, signer.SignerOptionsFromConfig(s, nil))
+		}
+		return signers, nil
+	}
+
+	vsigners, err := newSigners(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range c.Signers {
+		signers = append(signers, signer.SignerOptionsFromConfig(s, vsigners[s.Name]))
+	}
+	return signers, nil
+}
+
+func inspectCSR(csr *capi.CertificateSigningRequest, opts signer.SignerOptions, p *policy.Policy) csrReport {
+	r := csrReport{
+		Name:              csr.Name,
+		SignerName:        csr.Spec.SignerName,
+		Username:          csr.Spec.Username,
+		Usages:            csr.Spec.Usages,
+		ExpirationSeconds: csr.Spec.ExpirationSeconds,
+	}
+	for _, cond := range csr.Status.Conditions {
+		r.Conditions = append(r.Conditions, conditionReport{Type: cond.Type, Reason: cond.Reason, Message: cond.Message})
+	}
+
+	if x509cr, err := api.ParseCSR(csr.Spec.Request); err == nil {
+		r.Subject = x509cr.Subject.String()
+		r.DNSNames = x509cr.DNSNames
+		r.EmailAddresses = x509cr.EmailAddresses
+		for _, ip := range x509cr.IPAddresses {
+			r.IPAddresses = append(r.IPAddresses, ip.String())
+		}
+		for _, uri := range x509cr.URIs {
+			r.URIs = append(r.URIs, uri.String())
+		}
+	}
+
+	if err := signer.Check(opts, p, csr); err != nil {
+		r.CheckError = err.Error()
+	} else {
+		r.WouldSign = true
+	}
+
+	if len(csr.Status.Certificate) > 0 {
+		if certs, err := api.ParseCertificates(csr.Status.Certificate); err == nil && len(certs) > 0 {
+			r.Certificate = &certificateReport{
+				Serial:    hex.EncodeToString(certs[0].SerialNumber.Bytes()),
+				NotBefore: certs[0].NotBefore,
+				NotAfter:  certs[0].NotAfter,
+				Issuer:    certs[0].Issuer.String(),
+			}
+		}
+	}
+
+	return r
+}
+
+func printReports(out io.Writer, reports []csrReport) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIGNER\tREQUESTOR\tSUBJECT\tSANS\tUSAGES\tCONDITION\tWOULD SIGN\tSERIAL\tNOT BEFORE\tNOT AFTER\tISSUER")
+	for _, r := range reports {
+		sans := append(append(append(append([]string{}, r.DNSNames...), r.IPAddresses...), r.URIs...), r.EmailAddresses...)
+		usages := make([]string, 0, len(r.Usages))
+		for _, u := range r.Usages {
+			usages = append(usages, string(u))
+		}
+		conditions := make([]string, 0, len(r.Conditions))
+		for _, cond := range r.Conditions {
+			if cond.Reason != "" {
+				conditions = append(conditions, fmt.Sprintf("%s(%s)", cond.Type, cond.Reason))
+			} else {
+				conditions = append(conditions, string(cond.Type))
+			}
+		}
+		wouldSign := "yes"
+		if !r.WouldSign {
+			wouldSign = "no: " + r.CheckError
+		}
+		serial, notBefore, notAfter, issuer := "-", "-", "-", "-"
+		if r.Certificate != nil {
+			serial = r.Certificate.Serial
+			notBefore = r.Certificate.NotBefore.Format(time.RFC3339)
+			notAfter = r.Certificate.NotAfter.Format(time.RFC3339)
+			issuer = r.Certificate.Issuer
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Name, r.SignerName, r.Username, orDash(r.Subject), orDash(strings.Join(sans, ",")), orDash(strings.Join(usages, ",")),
+			orDash(strings.Join(conditions, ",")), wouldSign, serial, notBefore, notAfter, issuer)
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/spf13/cobra"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/certificate/csr"
+	"k8s.io/klog/v2"
+)
+
+type requestOptions struct {
+	commonName string
+	dnsNames   []string
+	uris       []string
+	usages     []string
+	duration   time.Duration
+	signerName string
+	dir        string
+	timeout    time.Duration
+}
+
+// NewRequestCommand creates a command that requests a certificate through a CSR and stores it
+// in a directory, as done by the init containers injected in Pods
+func NewRequestCommand(c *config.Config) *cobra.Command {
+	o := &requestOptions{
+		signerName: api.VaultSignerName,
+		usages:     []string{string(capi.UsageDigitalSignature), string(capi.UsageKeyEncipherment), string(capi.UsageServerAuth), string(capi.UsageClientAuth)},
+		duration:   24 * time.Hour,
+		timeout:    5 * time.Minute,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "request",
+		Short: "Request a certificate through a Kubernetes CSR and store it in a directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Load(cmd.Flags()); err != nil {
+				return err
+			}
+			return o.run(cmd.Context(), c)
+		},
+	}
+
+	cmd.Flags().StringVar(&o.commonName, "common-name", o.commonName, "Common name of the requested certificate.")
+	cmd.Flags().StringSliceVar(&o.dnsNames, "dns-names", o.dnsNames, "DNS names of the requested certificate.")
+	cmd.Flags().StringSliceVar(&o.uris, "uris", o.uris, "URI SANs of the requested certificate.")
+	cmd.Flags().StringSliceVar(&o.usages, "usages", o.usages, "Key usages of the requested certificate.")
+	cmd.Flags().DurationVar(&o.duration, "duration", o.duration, "Duration of the requested certificate.")
+	cmd.Flags().StringVar(&o.signerName, "signer-name", o.signerName, "Name of the signer that should sign the certificate.")
+	cmd.Flags().StringVar(&o.dir, "dir", o.dir, "Directory where the private key and the certificate are stored.")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", o.timeout, "Maximum time to wait for the certificate to be issued.")
+	_ = cmd.MarkFlagRequired("common-name")
+	_ = cmd.MarkFlagRequired("dir")
+
+	return cmd
+}
+
+func (o *requestOptions) run(ctx context.Context, c *config.Config) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes config from flags: %s", err)
+	}
+	kclient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error creating kubernetes client from config: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("unable to generate private key: %v", err)
+	}
+	var uris []*url.URL
+	for _, s := range o.uris {
+		uri, err := url.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid URI %q: %v", s, err)
+		}
+		uris = append(uris, uri)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: o.commonName},
+		DNSNames: o.dnsNames,
+		URIs:     uris,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("unable to create certificate request: %v", err)
+	}
+
+	usages := make([]capi.KeyUsage, 0, len(o.usages))
+	for _, usage := range o.usages {
+		usages = append(usages, capi.KeyUsage(usage))
+	}
+
+	reqName, reqUID, err := csr.RequestCertificate(
+		kclient,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+		"",
+		o.signerName,
+		&o.duration,
+		usages,
+		key,
+	)
+	if err != nil {
+		return err
+	}
+	klog.Infof("waiting for csr %s to be approved and signed", reqName)
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	cert, err := csr.WaitForCertificate(ctx, kclient, reqName, reqUID)
+	if err != nil {
+		return fmt.Errorf("error waiting for csr %s: %v", reqName, err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to encode private key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(o.dir, v1.TLSPrivateKeyKey), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("unable to write private key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(o.dir, v1.TLSCertKey), cert, 0644); err != nil {
+		return fmt.Errorf("unable to write certificate: %v", err)
+	}
+
+	klog.Infof("certificate stored in %s", o.dir)
+	return nil
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates/signer"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	"github.com/spf13/cobra"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/util/certificate/csr"
+)
+
+type signOptions struct {
+	file       string
+	usages     []string
+	duration   time.Duration
+	signerName string
+	chain      bool
+}
+
+// NewSignCommand creates a command that signs a PEM-encoded CSR with Vault without going through the
+// Kubernetes API, e.g., for break-glass procedures or to bootstrap a control plane
+func NewSignCommand(c *config.Config) *cobra.Command {
+	o := &signOptions{
+		signerName: api.VaultSignerName,
+		usages:     []string{string(capi.UsageDigitalSignature), string(capi.UsageKeyEncipherment), string(capi.UsageServerAuth), string(capi.UsageClientAuth)},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign a PEM-encoded CSR read from a file or stdin and write the certificate to stdout",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Load(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := c.Validate(); err != nil {
+				return fmt.Errorf("invalid configuration: %v", err)
+			}
+			return o.run(cmd, c)
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.file, "file", "f", o.file, "Path of the PEM-encoded CSR. If empty or -, the CSR is read from stdin.")
+	cmd.Flags().StringSliceVar(&o.usages, "usages", o.usages, "Key usages of the certificate.")
+	cmd.Flags().DurationVar(&o.duration, "duration", o.duration, "Duration of the certificate. Defaults to the signing duration of the signer.")
+	cmd.Flags().StringVar(&o.signerName, "signer-name", o.signerName, "Name of the configured signer whose Vault PKI and role are used.")
+	cmd.Flags().BoolVar(&o.chain, "chain", o.chain, "Append the CA certificate to the output.")
+
+	return cmd
+}
+
+func (o *signOptions) run(cmd *cobra.Command, c *config.Config) error {
+	s := c.Signer(o.signerName)
+	if s == nil {
+		return fmt.Errorf("unknown signer %s", o.signerName)
+	}
+
+	var in io.Reader = cmd.InOrStdin()
+	if o.file != "" && o.file != "-" {
+		f, err := os.Open(o.file)
+		if err != nil {
+			return fmt.Errorf("unable to open csr: %v", err)
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+	pemBytes, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("unable to read csr: %v", err)
+	}
+	x509cr, err := api.ParseCSR(pemBytes)
+	if err != nil {
+		return fmt.Errorf("unable to parse csr: %v", err)
+	}
+
+	usages := make([]capi.KeyUsage, 0, len(o.usages))
+	for _, usage := range o.usages {
+		usages = append(usages, capi.KeyUsage(usage))
+	}
+
+	var expirationSeconds *int32
+	if o.duration > 0 {
+		expirationSeconds = csr.DurationToExpirationSeconds(o.duration)
+	}
+
+	// the signing policy is evaluated against a CSR object that is never submitted to the apiserver
+	policies, err := c.Policies()
+	if err != nil {
+		return fmt.Errorf("error loading signing policy: %v", err)
+	}
+	if s.Verbatim && policies[s.Name].Empty() {
+		return fmt.Errorf("signer %s uses sign-verbatim, which requires a signing policy", s.Name)
+	}
+	result := policies[s.Name].Evaluate(&capi.CertificateSigningRequest{
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:           pemBytes,
+			SignerName:        s.Name,
+			ExpirationSeconds: expirationSeconds,
+			Usages:            usages,
+		},
+	}, x509cr)
+	if result.Decision != policy.Sign {
+		return fmt.Errorf("csr rejected by signing policy: %s", result.Message)
+	}
+
+	signers, err := newSigners(cmd.Context(), c)
+	if err != nil {
+		return err
+	}
+	vsigner := signers[s.Name]
+
+	cert, err := signer.SignRequest(cmd.Context(), vsigner, x509cr, usages, signer.Duration(s.SigningDuration.Duration, expirationSeconds))
+	if err != nil {
+		return err
+	}
+	if o.chain {
+		ca, err := vsigner.CA()
+		if err != nil {
+			return err
+		}
+		cert = append(cert, ca...)
+		if !bytes.HasSuffix(cert, []byte("\n")) {
+			cert = append(cert, '\n')
+		}
+	}
+
+	_, err = cmd.OutOrStdout().Write(cert)
+	return err
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+	vault "github.com/alpha-unito/k8s-vault-signer/pkg/vault/client"
+	"github.com/hashicorp/vault/api"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newSigners creates the configured signers, authenticating with Vault only if any signer is backed by it
+func newSigners(ctx context.Context, c *config.Config) (map[string]signing.Signer, error) {
+	var vclient *api.Client
+	if c.UsesVault() {
+		var err error
+		vclient, err = vault.NewClient(c.Vault.Address, false)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Vault client: %v", err)
+		}
+		authenticator, err := c.Authenticator()
+		if err != nil {
+			return nil, fmt.Errorf("error creating Vault authenticator: %v", err)
+		}
+		if _, err := authenticator.Authenticate(ctx, vclient); err != nil {
+			return nil, fmt.Errorf("error authenticating with Vault: %v", err)
+		}
+	}
+
+	var kclient kubernetes.Interface
+	for _, s := range c.Signers {
+		if s.Local != nil && s.Local.SecretName != "" {
+			cfg, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
+			if err != nil {
+				return nil, fmt.Errorf("error building kubernetes config from flags: %s", err)
+			}
+			kclient, err = kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("error creating kubernetes client from config: %s", err)
+			}
+			break
+		}
+	}
+
+	signers := make(map[string]signing.Signer, len(c.Signers))
+	for _, s := range c.Signers {
+		sgnr, err := signing.NewSigner(ctx, vclient, kclient, s)
+		if err != nil {
+			return nil, fmt.Errorf("error creating signer %s: %v", s.Name, err)
+		}
+		signers[s.Name] = sgnr
+	}
+	return signers, nil
+}
@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// NewValidateConfigCommand creates a command that loads and validates the configuration without
+// starting any controller, so that configuration files can be checked before a rollout
+func NewValidateConfigCommand(c *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate-config",
+		Short: "Validate the vault-signer configuration and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.Load(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := c.Validate(); err != nil {
+				return fmt.Errorf("invalid configuration: %v", err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+			return nil
+		},
+	}
+}
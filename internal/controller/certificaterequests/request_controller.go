@@ -0,0 +1,416 @@
+package certificaterequests
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	controller "github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+type CertificateRequestController struct {
+	kubeClient    clientset.Interface
+	dynamicClient dynamic.Interface
+	requestLister cache.GenericLister
+	csrLister     certificateslisters.CertificateSigningRequestLister
+	nsLister      corelisters.NamespaceLister
+	cacheSynced   []cache.InformerSynced
+	signerName    string
+	trustDomain   string
+	queue         workqueue.RateLimitingInterface
+}
+
+func NewCertificateRequestController(
+	ctx context.Context,
+	kubeClient clientset.Interface,
+	dynamicClient dynamic.Interface,
+	requestInformer informers.GenericInformer,
+	csrInformer certificatesinformers.CertificateSigningRequestInformer,
+	nsInformer coreinformers.NamespaceInformer,
+	signerName string,
+	trustDomain string,
+	rateLimiter workqueue.RateLimiter,
+) *CertificateRequestController {
+	logger := klog.FromContext(ctx)
+	rc := &CertificateRequestController{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		signerName:    signerName,
+		trustDomain:   trustDomain,
+		queue:         workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{Name: "certificaterequest"}),
+	}
+
+	_, err := requestInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			logger.V(4).Info("Adding Vault certificate request", "request", klog.KObj(obj.(metav1.Object)))
+			rc.enqueue(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			logger.V(4).Info("Updating Vault certificate request", "request", klog.KObj(new.(metav1.Object)))
+			rc.enqueue(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			logger.V(4).Info("Deleting Vault certificate request")
+			rc.enqueue(obj)
+		},
+	})
+	if err != nil {
+		klog.Exitf("Error adding certificate request controller event handler: %v", err)
+	}
+
+	_, err = csrInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: rc.enqueueOwner,
+		UpdateFunc: func(old, new interface{}) {
+			rc.enqueueOwner(new)
+		},
+	})
+	if err != nil {
+		klog.Exitf("Error adding certificate request controller event handler: %v", err)
+	}
+
+	rc.requestLister = requestInformer.Lister()
+	rc.csrLister = csrInformer.Lister()
+	rc.nsLister = nsInformer.Lister()
+	rc.cacheSynced = []cache.InformerSynced{
+		requestInformer.Informer().HasSynced,
+		csrInformer.Informer().HasSynced,
+		nsInformer.Informer().HasSynced,
+	}
+
+	return rc
+}
+
+func (rc *CertificateRequestController) Run(ctx context.Context, workers int, drainTimeout time.Duration) {
+	defer utilruntime.HandleCrash()
+	defer rc.queue.ShutDown()
+
+	logger := klog.FromContext(ctx)
+	logger.Info("Starting certificate request controller")
+	defer logger.Info("Shutting down certificate request controller")
+
+	if !cache.WaitForNamedCacheSync("certificaterequest", ctx.Done(), rc.cacheSynced...) {
+		return
+	}
+
+	controller.RunWorkers(ctx, rc.queue, workers, drainTimeout, rc.worker)
+}
+
+func (rc *CertificateRequestController) worker(stop, ctx context.Context) {
+	for rc.processNextWorkItem(stop, ctx) {
+	}
+}
+
+func (rc *CertificateRequestController) processNextWorkItem(stop, ctx context.Context) bool {
+	key, quit := rc.queue.Get()
+	if quit {
+		return false
+	}
+	defer rc.queue.Done(key)
+	if controller.Stopped(stop) {
+		return false
+	}
+
+	if err := rc.syncFunc(ctx, key.(string)); err != nil {
+		rc.queue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("sync %v failed with: %v", key, err))
+		return true
+	}
+
+	rc.queue.Forget(key)
+	return true
+}
+
+func (rc *CertificateRequestController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", obj, err))
+		return
+	}
+	rc.queue.Add(key)
+}
+
+func (rc *CertificateRequestController) enqueueOwner(obj interface{}) {
+	csr, ok := obj.(*capi.CertificateSigningRequest)
+	if !ok {
+		return
+	}
+	namespace, name := csr.Labels[v1alpha1.RequestNamespaceLabel], csr.Labels[v1alpha1.RequestNameLabel]
+	if namespace == "" || name == "" {
+		return
+	}
+	rc.queue.Add(namespace + "/" + name)
+}
+
+func (rc *CertificateRequestController) syncFunc(ctx context.Context, key string) error {
+	logger := klog.FromContext(ctx)
+	startTime := time.Now()
+	defer func() {
+		logger.V(4).Info("Finished syncing Vault certificate request", "request", key, "elapsedTime", time.Since(startTime))
+	}()
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, err := rc.requestLister.ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		logger.V(3).Info("Vault certificate request has been deleted", "request", key)
+		return rc.deleteCSRs(ctx, namespace, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	req := &v1alpha1.VaultCertificateRequest{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).UnstructuredContent(), req); err != nil {
+		return fmt.Errorf("unable to decode Vault certificate request %s: %v", key, err)
+	}
+	status := req.Status.DeepCopy()
+
+	if err := rc.reconcile(ctx, req); err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(status, &req.Status) {
+		return nil
+	}
+	return rc.updateStatus(ctx, req)
+}
+
+func (rc *CertificateRequestController) reconcile(ctx context.Context, req *v1alpha1.VaultCertificateRequest) error {
+	if ready := meta.FindStatusCondition(req.Status.Conditions, v1alpha1.ConditionReady); ready != nil {
+		switch ready.Reason {
+		case v1alpha1.ReasonIssued:
+			return rc.ensureSecret(ctx, req)
+		case v1alpha1.ReasonInvalid, v1alpha1.ReasonDenied, v1alpha1.ReasonFailed:
+			return nil
+		}
+	}
+
+	ns, err := rc.nsLister.Get(req.Namespace)
+	if err != nil {
+		return err
+	}
+	nsPolicy := policy.NewNamespacePolicy(ns, rc.trustDomain)
+
+	x509cr, err := api.ParseCSR(req.Spec.Request)
+	if err != nil {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonInvalid, fmt.Sprintf("unable to parse request: %v", err))
+		return nil
+	}
+	if err := nsPolicy.ValidateRequest(x509cr, req.Spec.Usages); err != nil {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonInvalid, err.Error())
+		return nil
+	}
+
+	csrName := requestCSRName(req)
+	csr, err := rc.csrLister.Get(csrName)
+	if errors.IsNotFound(err) {
+		csr, err = rc.createCSR(ctx, req, csrName)
+	}
+	if err != nil {
+		return err
+	}
+	// the name of the CSR is predictable, so a CSR created by someone else must not be trusted
+	if !bytes.Equal(csr.Spec.Request, req.Spec.Request) || csr.Spec.SignerName != rc.signerName {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonFailed, fmt.Sprintf("csr %s does not match the request", csr.Name))
+		return nil
+	}
+	req.Status.CertificateSigningRequestName = csr.Name
+
+	if approved, denied := controller.GetCertApprovalCondition(&csr.Status); denied {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonDenied, conditionMessage(csr, capi.CertificateDenied))
+		return nil
+	} else if controller.HasTrueCondition(csr, capi.CertificateFailed) {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonFailed, conditionMessage(csr, capi.CertificateFailed))
+		return nil
+	} else if len(csr.Status.Certificate) == 0 {
+		message := "waiting for approval"
+		if approved {
+			message = "waiting for signing"
+		}
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonPending, message)
+		return nil
+	}
+
+	cert, err := parseCertificate(csr.Status.Certificate)
+	if err != nil {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonFailed, fmt.Sprintf("invalid issued certificate: %v", err))
+		return nil
+	}
+	if err := nsPolicy.ValidateCertificate(cert); err != nil {
+		setReady(req, metav1.ConditionFalse, v1alpha1.ReasonFailed, fmt.Sprintf("issued certificate violates namespace policy: %v", err))
+		return nil
+	}
+
+	req.Status.Certificate = csr.Status.Certificate
+	if err := rc.ensureSecret(ctx, req); err != nil {
+		return err
+	}
+	setReady(req, metav1.ConditionTrue, v1alpha1.ReasonIssued, "certificate issued")
+	return nil
+}
+
+func (rc *CertificateRequestController) createCSR(ctx context.Context, req *v1alpha1.VaultCertificateRequest, name string) (*capi.CertificateSigningRequest, error) {
+	csr := &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				v1alpha1.RequestNamespaceLabel: req.Namespace,
+				v1alpha1.RequestNameLabel:      req.Name,
+			},
+			Annotations: map[string]string{
+				v1alpha1.RequesterAnnotation: policy.RequesterUsername(req.Namespace, req.Name),
+			},
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:           req.Spec.Request,
+			SignerName:        rc.signerName,
+			Usages:            req.Spec.Usages,
+			ExpirationSeconds: req.Spec.ExpirationSeconds,
+		},
+	}
+
+	created, err := rc.kubeClient.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return rc.kubeClient.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create csr for Vault certificate request %s/%s: %v", req.Namespace, req.Name, err)
+	}
+	klog.FromContext(ctx).V(2).Info("Created certificate signing request", "request", klog.KObj(req), "csr", created.Name)
+	return created, nil
+}
+
+func (rc *CertificateRequestController) deleteCSRs(ctx context.Context, namespace, name string) error {
+	csrs, err := rc.csrLister.List(labels.SelectorFromSet(labels.Set{
+		v1alpha1.RequestNamespaceLabel: namespace,
+		v1alpha1.RequestNameLabel:      name,
+	}))
+	if err != nil {
+		return err
+	}
+	for _, csr := range csrs {
+		err := rc.kubeClient.CertificatesV1().CertificateSigningRequests().Delete(ctx, csr.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete csr %s: %v", csr.Name, err)
+		}
+	}
+	return nil
+}
+
+func (rc *CertificateRequestController) ensureSecret(ctx context.Context, req *v1alpha1.VaultCertificateRequest) error {
+	if req.Spec.SecretName == "" {
+		return nil
+	}
+
+	secrets := rc.kubeClient.CoreV1().Secrets(req.Namespace)
+	secret, err := secrets.Get(ctx, req.Spec.SecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Spec.SecretName,
+				Namespace: req.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(req, v1alpha1.VaultCertificateRequestGVR.GroupVersion().WithKind(v1alpha1.VaultCertificateRequestKind)),
+				},
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: map[string][]byte{v1.TLSCertKey: req.Status.Certificate},
+		}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to create secret %s/%s: %v", req.Namespace, req.Spec.SecretName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(secret, req) {
+		return fmt.Errorf("secret %s/%s is not owned by Vault certificate request %s", req.Namespace, req.Spec.SecretName, req.Name)
+	}
+	if string(secret.Data[v1.TLSCertKey]) == string(req.Status.Certificate) {
+		return nil
+	}
+
+	secret = secret.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[v1.TLSCertKey] = req.Status.Certificate
+	_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to update secret %s/%s: %v", req.Namespace, req.Spec.SecretName, err)
+	}
+	return nil
+}
+
+func (rc *CertificateRequestController) updateStatus(ctx context.Context, req *v1alpha1.VaultCertificateRequest) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(req)
+	if err != nil {
+		return fmt.Errorf("unable to encode Vault certificate request %s/%s: %v", req.Namespace, req.Name, err)
+	}
+	_, err = rc.dynamicClient.Resource(v1alpha1.VaultCertificateRequestGVR).
+		Namespace(req.Namespace).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error updating status for Vault certificate request %s/%s: %v", req.Namespace, req.Name, err)
+	}
+	return nil
+}
+
+func setReady(req *v1alpha1.VaultCertificateRequest, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&req.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: req.Generation,
+	})
+}
+
+func conditionMessage(csr *capi.CertificateSigningRequest, conditionType capi.RequestConditionType) string {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == conditionType {
+			return strings.TrimSpace(fmt.Sprintf("%s: %s", c.Reason, c.Message))
+		}
+	}
+	return string(conditionType)
+}
+
+func parseCertificate(pemBytes []byte) (*x509.Certificate, error) {
+	certs, err := api.ParseCertificates(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
@@ -0,0 +1,22 @@
+package certificaterequests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func requestCSRName(req *v1alpha1.VaultCertificateRequest) string {
+	hash := sha256.Sum256([]byte(req.UID))
+	suffix := hex.EncodeToString(hash[:])[:8]
+	name := fmt.Sprintf("vcr-%s-%s", req.Namespace, req.Name)
+	if max := validation.DNS1123SubdomainMaxLength - len(suffix) - 1; len(name) > max {
+		name = name[:max]
+	}
+	return fmt.Sprintf("%s-%s", strings.TrimSuffix(name, "."), suffix)
+}
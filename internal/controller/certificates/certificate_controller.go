@@ -2,15 +2,17 @@ package certificates
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	capi "k8s.io/api/certificates/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	certificateslisters "k8s.io/client-go/listers/certificates/v1"
@@ -22,8 +24,8 @@ import (
 type CertificateController struct {
 	name       string
 	kubeClient clientset.Interface
-	csrLister  certificateslisters.CertificateSigningRequestLister
-	csrsSynced cache.InformerSynced
+	csrListers []certificateslisters.CertificateSigningRequestLister
+	csrsSynced []cache.InformerSynced
 	handler    func(context.Context, *capi.CertificateSigningRequest) error
 	queue      workqueue.RateLimitingInterface
 }
@@ -32,21 +34,20 @@ func NewCertificateController(
 	ctx context.Context,
 	name string,
 	kubeClient clientset.Interface,
-	csrInformer certificatesinformers.CertificateSigningRequestInformer,
+	csrInformers []certificatesinformers.CertificateSigningRequestInformer,
 	handler func(context.Context, *capi.CertificateSigningRequest) error,
+	rateLimiter workqueue.RateLimiter,
 ) *CertificateController {
 	logger := klog.FromContext(ctx)
 	cc := &CertificateController{
 		name:       name,
 		kubeClient: kubeClient,
-		queue: workqueue.NewRateLimitingQueueWithConfig(workqueue.NewMaxOfRateLimiter(
-			workqueue.NewItemExponentialFailureRateLimiter(200*time.Millisecond, 1000*time.Second),
-			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
-		), workqueue.RateLimitingQueueConfig{Name: "certificate"}),
-		handler: handler,
+		queue:      workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{Name: "certificate"}),
+		handler:    handler,
 	}
 
-	_, err := csrInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	// each informer may watch the CSRs of a different signer, as field selectors cannot match several values
+	handlers := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			csr := obj.(*capi.CertificateSigningRequest)
 			logger.V(4).Info("Adding certificate request", "csr", csr.Name)
@@ -74,18 +75,19 @@ func NewCertificateController(
 			logger.V(4).Info("Deleting certificate request", "csr", csr.Name)
 			cc.enqueueCertificateRequest(obj)
 		},
-	})
-	if err != nil {
-		klog.Exitf("Error adding certificate controller event handler: %v", err)
 	}
-
-	cc.csrLister = csrInformer.Lister()
-	cc.csrsSynced = csrInformer.Informer().HasSynced
+	for _, csrInformer := range csrInformers {
+		if _, err := csrInformer.Informer().AddEventHandler(handlers); err != nil {
+			klog.Exitf("Error adding certificate controller event handler: %v", err)
+		}
+		cc.csrListers = append(cc.csrListers, csrInformer.Lister())
+		cc.csrsSynced = append(cc.csrsSynced, csrInformer.Informer().HasSynced)
+	}
 
 	return cc
 }
 
-func (cc *CertificateController) Run(ctx context.Context, workers int) {
+func (cc *CertificateController) Run(ctx context.Context, workers int, drainTimeout time.Duration) {
 	defer utilruntime.HandleCrash()
 	defer cc.queue.ShutDown()
 
@@ -93,30 +95,36 @@ func (cc *CertificateController) Run(ctx context.Context, workers int) {
 	logger.Info("Starting certificate controller", "name", cc.name)
 	defer logger.Info("Shutting down certificate controller", "name", cc.name)
 
-	if !cache.WaitForNamedCacheSync(fmt.Sprintf("certificate-%s", cc.name), ctx.Done(), cc.csrsSynced) {
+	if !cache.WaitForNamedCacheSync(fmt.Sprintf("certificate-%s", cc.name), ctx.Done(), cc.csrsSynced...) {
 		return
 	}
 
-	for i := 0; i < workers; i++ {
-		go wait.UntilWithContext(ctx, cc.worker, time.Second)
-	}
-
-	<-ctx.Done()
+	RunWorkers(ctx, cc.queue, workers, drainTimeout, cc.worker)
 }
 
-func (cc *CertificateController) worker(ctx context.Context) {
-	for cc.processNextWorkItem(ctx) {
+func (cc *CertificateController) worker(stop, ctx context.Context) {
+	for cc.processNextWorkItem(stop, ctx) {
 	}
 }
 
-func (cc *CertificateController) processNextWorkItem(ctx context.Context) bool {
+func (cc *CertificateController) processNextWorkItem(stop, ctx context.Context) bool {
 	cKey, quit := cc.queue.Get()
 	if quit {
 		return false
 	}
 	defer cc.queue.Done(cKey)
+	if Stopped(stop) {
+		return false
+	}
 
 	if err := cc.syncFunc(ctx, cKey.(string)); err != nil {
+		var delayed retryAfterError
+		if stderrors.As(err, &delayed) {
+			// e.g., an open circuit breaker, which must not increase the backoff of the item
+			klog.FromContext(ctx).V(2).Info("sync certificate request delayed", "csr", cKey, "err", err)
+			cc.queue.AddAfter(cKey, delayed.RetryAfter())
+			return true
+		}
 		cc.queue.AddRateLimited(cKey)
 		if _, ignorable := err.(ignorableError); !ignorable {
 			utilruntime.HandleError(fmt.Errorf("sync %v failed with: %v", cKey, err))
@@ -140,13 +148,19 @@ func (cc *CertificateController) enqueueCertificateRequest(obj interface{}) {
 	cc.queue.Add(key)
 }
 
-func (cc *CertificateController) syncFunc(ctx context.Context, key string) error {
+func (cc *CertificateController) syncFunc(ctx context.Context, key string) (err error) {
 	logger := klog.FromContext(ctx)
 	startTime := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "CertificateController.sync", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("controller", cc.name),
+		tracing.CSRNameKey.String(key),
+		attribute.Int("workqueue.requeues", cc.queue.NumRequeues(key)),
+	))
 	defer func() {
+		tracing.End(span, err)
 		logger.V(4).Info("Finished syncing certificate request", "csr", key, "elapsedTime", time.Since(startTime))
 	}()
-	csr, err := cc.csrLister.Get(key)
+	csr, err := cc.GetCSR(key)
 	if errors.IsNotFound(err) {
 		logger.V(3).Info("csr has been deleted", "csr", key)
 		return nil
@@ -154,6 +168,7 @@ func (cc *CertificateController) syncFunc(ctx context.Context, key string) error
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(tracing.CSRUIDKey.String(string(csr.UID)), tracing.SignerNameKey.String(csr.Spec.SignerName))
 
 	if len(csr.Status.Certificate) > 0 {
 		// no need to do anything because it already has a cert
@@ -165,10 +180,28 @@ func (cc *CertificateController) syncFunc(ctx context.Context, key string) error
 	return cc.handler(ctx, csr)
 }
 
+// GetCSR looks up the CSR in the listers of all the informers
+func (cc *CertificateController) GetCSR(name string) (*capi.CertificateSigningRequest, error) {
+	var err error
+	for _, lister := range cc.csrListers {
+		var csr *capi.CertificateSigningRequest
+		if csr, err = lister.Get(name); err == nil || !errors.IsNotFound(err) {
+			return csr, err
+		}
+	}
+	return nil, err
+}
+
 func IgnorableError(s string, args ...interface{}) ignorableError {
 	return ignorableError(fmt.Sprintf(s, args...))
 }
 
+// retryAfterError is implemented by errors that retry the request after a given delay
+type retryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
 type ignorableError string
 
 func (e ignorableError) Error() string {
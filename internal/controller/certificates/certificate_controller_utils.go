@@ -1,8 +1,15 @@
 package certificates
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
 	capi "k8s.io/api/certificates/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func IsCertificateRequestApproved(csr *capi.CertificateSigningRequest) bool {
@@ -30,3 +37,48 @@ func GetCertApprovalCondition(status *capi.CertificateSigningRequestStatus) (app
 	}
 	return
 }
+
+// RateLimiterOptions configures the per-item exponential backoff and the overall rate of a workqueue
+type RateLimiterOptions struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	QPS       float64
+	Burst     int
+}
+
+// NewRateLimiter returns a new rate limiter, which must not be shared among workqueues
+func NewRateLimiter(opts RateLimiterOptions) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(opts.BaseDelay, opts.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)},
+	)
+}
+
+// RunWorkers runs the workers until the context is done, then shuts down the queue and waits for the items
+// being processed. Workers must stop taking items once stop is done, and process them with ctx, which outlives
+// stop by drainTimeout, so that in-flight syncs are not interrupted, e.g., between signing a certificate and
+// publishing it, while the calls to Vault are still bounded
+func RunWorkers(stop context.Context, queue workqueue.Interface, workers int, drainTimeout time.Duration, worker func(stop, ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(stop))
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(stop, ctx)
+		}()
+	}
+
+	<-stop.Done()
+	queue.ShutDown()
+	timer := time.AfterFunc(drainTimeout, cancel)
+	defer timer.Stop()
+	wg.Wait()
+}
+
+// Stopped reports whether a worker must leave the item it got from the queue unprocessed, as the controller is
+// shutting down. The queue is shut down, so the item is left to the informers of the next instance
+func Stopped(stop context.Context) bool {
+	return stop.Err() != nil
+}
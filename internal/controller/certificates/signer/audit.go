@@ -0,0 +1,75 @@
+package signer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/audit"
+
+	capi "k8s.io/api/certificates/v1"
+)
+
+// record writes the outcome of the CSR to the audit sink, if any. The SANs are taken from the issued
+// certificate, or from the request if the CSR was rejected
+func (s *signer) record(ctx context.Context, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest, outcome audit.Outcome, reason, message string, certPEM []byte) error {
+	if s.audit == nil {
+		return nil
+	}
+
+	r := &audit.Record{
+		Time: time.Now().UTC(),
+		CSR:  csr.Name,
+		UID:  csr.UID,
+		Requester: audit.Requester{
+			Username: csr.Spec.Username,
+			UID:      csr.Spec.UID,
+			Groups:   csr.Spec.Groups,
+		},
+		Signer:         s.signerName,
+		Pki:            s.pki,
+		Role:           s.role,
+		Profile:        csr.Annotations[v1alpha1.ProfileAnnotation],
+		Outcome:        outcome,
+		Reason:         reason,
+		Message:        message,
+		Subject:        x509cr.Subject.String(),
+		DNSNames:       x509cr.DNSNames,
+		EmailAddresses: x509cr.EmailAddresses,
+	}
+	for _, c := range csr.Status.Conditions {
+		if c.Type == capi.CertificateApproved {
+			r.Approver = audit.Approver{Reason: c.Reason, Message: c.Message}
+		}
+	}
+	for _, usage := range csr.Spec.Usages {
+		r.Usages = append(r.Usages, string(usage))
+	}
+	ips, uris := x509cr.IPAddresses, x509cr.URIs
+
+	if block, _ := pem.Decode(certPEM); block != nil {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("unable to parse issued certificate: %v", err)
+		}
+		r.Serial = fmt.Sprintf("%x", cert.SerialNumber)
+		r.Subject = cert.Subject.String()
+		r.DNSNames, r.EmailAddresses = cert.DNSNames, cert.EmailAddresses
+		ips, uris = cert.IPAddresses, cert.URIs
+		r.NotBefore, r.NotAfter = &cert.NotBefore, &cert.NotAfter
+	}
+	for _, ip := range ips {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, uri := range uris {
+		r.URIs = append(r.URIs, uri.String())
+	}
+
+	if err := s.audit.Write(ctx, r); err != nil {
+		return fmt.Errorf("error recording csr %q: %v", csr.Name, err)
+	}
+	return nil
+}
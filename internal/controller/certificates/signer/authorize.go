@@ -0,0 +1,95 @@
+package signer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RequestVerb is the custom verb a requester must be granted on the signers resource
+	RequestVerb = "request"
+	// SubjectAltNamesResource is the custom resource whose names are the SANs a requester may ask for,
+	// prefixed by their type (dns:, ip:, uri:, email:)
+	SubjectAltNamesResource = "subjectaltnames"
+)
+
+// RequesterAuthorization requires the requester of a CSR to be authorized through SubjectAccessReviews
+type RequesterAuthorization struct {
+	// SubjectAltNames also requires the request verb on each requested SAN
+	SubjectAltNames bool
+}
+
+type unauthorizedError struct {
+	message string
+}
+
+func (e *unauthorizedError) Error() string {
+	return e.message
+}
+
+// authorize checks that the requester of the CSR is allowed to request certificates from the signer
+func (s *signer) authorize(ctx context.Context, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) error {
+	if s.authorization == nil {
+		return nil
+	}
+
+	attributes := []authorizationv1.ResourceAttributes{
+		{Group: capi.GroupName, Resource: "signers", Name: s.signerName, Verb: RequestVerb},
+	}
+	if s.authorization.SubjectAltNames {
+		for _, san := range subjectAltNames(x509cr) {
+			attributes = append(attributes, authorizationv1.ResourceAttributes{
+				Group: v1alpha1.GroupName, Resource: SubjectAltNamesResource, Name: san, Verb: RequestVerb,
+			})
+		}
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(csr.Spec.Extra))
+	for k, v := range csr.Spec.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	for i := range attributes {
+		review, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &attributes[i],
+				User:               csr.Spec.Username,
+				Groups:             csr.Spec.Groups,
+				UID:                csr.Spec.UID,
+				Extra:              extra,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to create SubjectAccessReview: %v", err)
+		}
+		if !review.Status.Allowed {
+			return &unauthorizedError{message: fmt.Sprintf("user %q is not allowed to %s %s %q", csr.Spec.Username, RequestVerb, attributes[i].Resource, attributes[i].Name)}
+		}
+	}
+
+	return nil
+}
+
+func subjectAltNames(cr *x509.CertificateRequest) []string {
+	var sans []string
+	for _, name := range cr.DNSNames {
+		sans = append(sans, "dns:"+name)
+	}
+	for _, ip := range cr.IPAddresses {
+		sans = append(sans, "ip:"+ip.String())
+	}
+	for _, uri := range cr.URIs {
+		sans = append(sans, "uri:"+uri.String())
+	}
+	for _, email := range cr.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	return sans
+}
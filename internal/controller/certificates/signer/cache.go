@@ -0,0 +1,173 @@
+package signer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// IssuanceCache remembers the certificates issued for each CSR for a bounded time, so that a CSR whose
+// verification, recording, or status update failed is not signed again when it is retried. The cache is keyed
+// by the CSR UID and checked against a hash of the request, and is optionally persisted to a file to survive
+// restarts
+type IssuanceCache struct {
+	ttl  time.Duration
+	path string
+
+	lock    sync.Mutex
+	entries map[types.UID]*Issuance
+}
+
+// Issuance is a certificate issued for a CSR
+type Issuance struct {
+	Hash string `json:"hash"`
+	// Chain is the PEM-encoded certificate followed by the CA chain returned by the backend
+	Chain  []byte    `json:"chain"`
+	Issued time.Time `json:"issued"`
+	// Recorded reports whether the certificate was written to the audit sink
+	Recorded bool      `json:"recorded,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// NewIssuanceCache creates a cache keeping the certificates for ttl, loading the entries persisted at path
+// if not empty. A nil cache, returned if ttl is zero, never hits
+func NewIssuanceCache(ttl time.Duration, path string) (*IssuanceCache, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	c := &IssuanceCache{ttl: ttl, path: path, entries: make(map[types.UID]*Issuance)}
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read issuance cache %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("unable to decode issuance cache %s: %v", path, err)
+	}
+	c.prune(time.Now())
+	return c, nil
+}
+
+// Get returns a copy of the issuance of the CSR, if it is cached and the request did not change
+func (c *IssuanceCache) Get(csr *capi.CertificateSigningRequest) (Issuance, bool) {
+	if c == nil {
+		return Issuance{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[csr.UID]
+	if !ok || len(e.Chain) == 0 || e.Hash != requestHash(csr) || time.Now().After(e.Expires) {
+		return Issuance{}, false
+	}
+	return *e, true
+}
+
+// Put caches the chain issued for the CSR at the given time, returning the issuance. Failures to persist the
+// cache are only logged, as they do not affect the certificate
+func (c *IssuanceCache) Put(csr *capi.CertificateSigningRequest, chain []byte, issued time.Time) Issuance {
+	e := Issuance{Hash: requestHash(csr), Chain: chain, Issued: issued}
+	if c == nil {
+		return e
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.prune(now)
+	e.Expires = now.Add(c.ttl)
+	c.entries[csr.UID] = &e
+	if err := c.save(); err != nil {
+		klog.Errorf("error persisting issuance cache: %v", err)
+	}
+	return e
+}
+
+// MarkRecorded notes that the certificate issued for the CSR was written to the audit sink, so that retries do
+// not record it again
+func (c *IssuanceCache) MarkRecorded(csr *capi.CertificateSigningRequest) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[csr.UID]; ok {
+		e.Recorded = true
+		if err := c.save(); err != nil {
+			klog.Errorf("error persisting issuance cache: %v", err)
+		}
+	}
+}
+
+func (c *IssuanceCache) prune(now time.Time) {
+	for uid, e := range c.entries {
+		if now.After(e.Expires) {
+			delete(c.entries, uid)
+		}
+	}
+}
+
+// save atomically replaces the cache file
+func (c *IssuanceCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// requestHash identifies the signed content of the CSR
+func requestHash(csr *capi.CertificateSigningRequest) string {
+	h := sha256.New()
+	h.Write([]byte(csr.Spec.SignerName))
+	h.Write([]byte{0})
+	h.Write(csr.Spec.Request)
+	usages := make([]string, len(csr.Spec.Usages))
+	for i, u := range csr.Spec.Usages {
+		usages[i] = string(u)
+	}
+	slices.Sort(usages)
+	for _, u := range usages {
+		h.Write([]byte{0})
+		h.Write([]byte(u))
+	}
+	if csr.Spec.ExpirationSeconds != nil {
+		_ = binary.Write(h, binary.BigEndian, *csr.Spec.ExpirationSeconds)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
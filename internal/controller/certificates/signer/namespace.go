@@ -0,0 +1,60 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+
+	capi "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type namespaceError struct {
+	message string
+}
+
+func (e *namespaceError) Error() string {
+	return e.message
+}
+
+// requester returns the CSR as requested by the identity of the VaultCertificateRequest it was created for by
+// the request controller, so that the checks, the authorization, and the audit log do not see the controller
+func (s *signer) requester(csr *capi.CertificateSigningRequest) (*capi.CertificateSigningRequest, error) {
+	if s.requestController == "" || csr.Spec.Username != s.requestController {
+		return csr, nil
+	}
+	namespace, name := csr.Labels[v1alpha1.RequestNamespaceLabel], csr.Labels[v1alpha1.RequestNameLabel]
+	if namespace == "" || name == "" {
+		return nil, &namespaceError{message: fmt.Sprintf("csr created by %q without the %s and %s labels", csr.Spec.Username, v1alpha1.RequestNamespaceLabel, v1alpha1.RequestNameLabel)}
+	}
+	username := policy.RequesterUsername(namespace, name)
+	if requester := csr.Annotations[v1alpha1.RequesterAnnotation]; requester != username {
+		return nil, &namespaceError{message: fmt.Sprintf("requester %q does not match the request %s/%s", requester, namespace, name)}
+	}
+
+	csr = csr.DeepCopy()
+	csr.Spec.Username = username
+	csr.Spec.Groups = policy.RequesterGroups(namespace)
+	csr.Spec.UID = ""
+	csr.Spec.Extra = nil
+	return csr, nil
+}
+
+// namespacePolicy returns the policy of the namespace the CSR was created for by the request controller, if any
+func (s *signer) namespacePolicy(ctx context.Context, csr *capi.CertificateSigningRequest) (*policy.NamespacePolicy, error) {
+	namespace := csr.Labels[v1alpha1.RequestNamespaceLabel]
+	if namespace == "" {
+		return nil, nil
+	}
+
+	ns, err := s.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, &namespaceError{message: fmt.Sprintf("namespace %s not found", namespace)}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get namespace %s: %v", namespace, err)
+	}
+	return policy.NewNamespacePolicy(ns, s.trustDomain), nil
+}
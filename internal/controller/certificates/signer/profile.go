@@ -0,0 +1,195 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/util/certificate/csr"
+)
+
+// Profile restricts the certificates a CSR can request when it selects the profile through the
+// vault.unito.it/profile annotation. SAN and common name templates are rendered with the requester identity
+type Profile struct {
+	Name                       string
+	Usages                     []capi.KeyUsage
+	MinDuration                time.Duration
+	MaxDuration                time.Duration
+	RequiredSubjectAttributes  []string
+	ForbiddenSubjectAttributes []string
+	CommonName                 string
+	DNSNames                   []string
+	URIs                       []string
+	IPAddresses                []string
+	EmailAddresses             []string
+}
+
+// ProfileData is the data available to the profile templates
+type ProfileData struct {
+	Username       string
+	Groups         []string
+	Namespace      string
+	ServiceAccount string
+}
+
+type compiledProfile struct {
+	Profile
+	commonName     *template.Template
+	dnsNames       []*template.Template
+	uris           []*template.Template
+	ipAddresses    []*template.Template
+	emailAddresses []*template.Template
+}
+
+func compileProfile(p Profile) (*compiledProfile, error) {
+	for _, attr := range append(slices.Clone(p.RequiredSubjectAttributes), p.ForbiddenSubjectAttributes...) {
+		if _, ok := config.SubjectAttributes[attr]; !ok {
+			return nil, fmt.Errorf("unknown subject attribute %q", attr)
+		}
+	}
+
+	cp := &compiledProfile{Profile: p}
+	var err error
+	if p.CommonName != "" {
+		if cp.commonName, err = config.ParseProfileTemplate(p.CommonName); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range []struct {
+		values []string
+		into   *[]*template.Template
+	}{
+		{p.DNSNames, &cp.dnsNames},
+		{p.URIs, &cp.uris},
+		{p.IPAddresses, &cp.ipAddresses},
+		{p.EmailAddresses, &cp.emailAddresses},
+	} {
+		for _, value := range t.values {
+			tmpl, err := config.ParseProfileTemplate(value)
+			if err != nil {
+				return nil, err
+			}
+			*t.into = append(*t.into, tmpl)
+		}
+	}
+	return cp, nil
+}
+
+func profileData(csr *capi.CertificateSigningRequest) ProfileData {
+	data := ProfileData{Username: csr.Spec.Username, Groups: csr.Spec.Groups}
+	if strings.HasPrefix(csr.Spec.Username, api.ServiceAccountUsernamePrefix) {
+		data.Namespace, data.ServiceAccount, _ = strings.Cut(strings.TrimPrefix(csr.Spec.Username, api.ServiceAccountUsernamePrefix), ":")
+	}
+	return data
+}
+
+// profile returns the profile selected by the CSR, if any
+func (s *signer) profile(csr *capi.CertificateSigningRequest) (*compiledProfile, error) {
+	name, ok := csr.Annotations[v1alpha1.ProfileAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	p, ok := s.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q for signer %s", name, s.signerName)
+	}
+	return p, nil
+}
+
+// validate checks that the request complies with the profile
+func (p *compiledProfile) validate(csrObj *capi.CertificateSigningRequest, req *x509.CertificateRequest) error {
+	if len(p.Usages) > 0 {
+		for _, usage := range csrObj.Spec.Usages {
+			if !slices.Contains(p.Usages, usage) {
+				return fmt.Errorf("usage %q is not allowed by profile %s", usage, p.Name)
+			}
+		}
+	}
+
+	if csrObj.Spec.ExpirationSeconds != nil && p.MinDuration > 0 {
+		if d := csr.ExpirationSecondsToDuration(*csrObj.Spec.ExpirationSeconds); d < p.MinDuration {
+			return fmt.Errorf("requested duration %s is shorter than the minimum %s of profile %s", d, p.MinDuration, p.Name)
+		}
+	}
+
+	for _, attr := range p.RequiredSubjectAttributes {
+		if !config.SubjectAttributes[attr](req.Subject) {
+			return fmt.Errorf("subject attribute %s is required by profile %s", attr, p.Name)
+		}
+	}
+	for _, attr := range p.ForbiddenSubjectAttributes {
+		if config.SubjectAttributes[attr](req.Subject) {
+			return fmt.Errorf("subject attribute %s is forbidden by profile %s", attr, p.Name)
+		}
+	}
+
+	data := profileData(csrObj)
+	if p.commonName != nil {
+		expected, err := render(p.commonName, data)
+		if err != nil {
+			return err
+		}
+		if req.Subject.CommonName != expected {
+			return fmt.Errorf("common name %q does not match %q required by profile %s", req.Subject.CommonName, expected, p.Name)
+		}
+	}
+
+	var ips, uris []string
+	for _, ip := range req.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	for _, uri := range req.URIs {
+		uris = append(uris, uri.String())
+	}
+	for _, sans := range []struct {
+		kind      string
+		requested []string
+		allowed   []*template.Template
+	}{
+		{"DNS", req.DNSNames, p.dnsNames},
+		{"URI", uris, p.uris},
+		{"IP", ips, p.ipAddresses},
+		{"email", req.EmailAddresses, p.emailAddresses},
+	} {
+		allowed, err := renderAll(sans.allowed, data)
+		if err != nil {
+			return err
+		}
+		for _, san := range sans.requested {
+			if !slices.Contains(allowed, san) {
+				return fmt.Errorf("%s SAN %q is not allowed by profile %s", sans.kind, san, p.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func render(tmpl *template.Template, data ProfileData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("unable to render template %q: %v", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+func renderAll(tmpls []*template.Template, data ProfileData) ([]string, error) {
+	values := make([]string, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		value, err := render(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
@@ -4,65 +4,305 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/url"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
 	controller "github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates"
-	"github.com/alpha-unito/k8s-vault-signer/pkg/vault/sign"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/audit"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/inventory"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	capi "k8s.io/api/certificates/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/certificate/csr"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
 )
 
 type CSRSigningController struct {
 	certificateController *controller.CertificateController
+	client                clientset.Interface
+	audit                 audit.Sink
+	cache                 *IssuanceCache
+	inventory             *inventory.Inventory
+
+	lock    sync.RWMutex
+	signers map[string]*signer
+}
+
+type SignerOptions struct {
+	Name   string
+	Signer signing.Signer
+	// Vault PKI mount and role of the signer, empty for local CAs
+	Pki     string
+	Role    string
+	CertTTL time.Duration
+	SPIFFE  *SPIFFEProfile
+	// RequirePolicy rejects the signer unless it has a non-empty signing policy
+	RequirePolicy bool
+	Authorization *RequesterAuthorization
+	Profiles      []Profile
+	// RequestController is the username of the controller creating CSRs for VaultCertificateRequests, whose
+	// CSRs must comply with the policy of the namespace of the request
+	RequestController string
 }
 
 func NewVaultCSRSigningController(
 	ctx context.Context,
 	client clientset.Interface,
-	csrInformer certificatesinformers.CertificateSigningRequestInformer,
-	vsigner *sign.VaultSigner,
-	certTTL time.Duration,
+	csrInformers []certificatesinformers.CertificateSigningRequestInformer,
+	signers []SignerOptions,
+	policies map[string]*policy.Policy,
+	sink audit.Sink,
+	cache *IssuanceCache,
+	inv *inventory.Inventory,
+	rateLimiter workqueue.RateLimiter,
 ) (*CSRSigningController, error) {
 
-	signer := &signer{
-		client:               client,
-		vsigner:              vsigner,
-		certTTL:              certTTL,
-		signerName:           api.VaultSignerName,
-		isRequestForSignerFn: isVaultSigner,
-	}
-
-	return &CSRSigningController{
-		certificateController: controller.NewCertificateController(
-			ctx,
-			"csrsigning-auth",
-			client,
-			csrInformer,
-			signer.handle,
-		),
-	}, nil
+	c := &CSRSigningController{client: client, audit: sink, cache: cache, inventory: inv}
+	if err := c.UpdateSigners(signers, policies); err != nil {
+		return nil, err
+	}
+
+	c.certificateController = controller.NewCertificateController(
+		ctx,
+		"csrsigning-auth",
+		client,
+		csrInformers,
+		c.handle,
+		rateLimiter,
+	)
+
+	return c, nil
+}
+
+// UpdateSigners atomically replaces the signers and policies used for new CSRs. The previous signers
+// are kept if the new ones are invalid
+func (c *CSRSigningController) UpdateSigners(signers []SignerOptions, policies map[string]*policy.Policy) error {
+	mapping := make(map[string]*signer, len(signers))
+	for _, opts := range signers {
+		if _, ok := mapping[opts.Name]; ok {
+			return fmt.Errorf("duplicate signer %s", opts.Name)
+		}
+		if opts.RequirePolicy && policies[opts.Name].Empty() {
+			return fmt.Errorf("signer %s requires a signing policy", opts.Name)
+		}
+		s, err := newSigner(c.client, opts, policies[opts.Name])
+		if err != nil {
+			return err
+		}
+		s.audit = c.audit
+		s.cache = c.cache
+		s.inventory = c.inventory
+		s.getCSR = c.getCSR
+		mapping[opts.Name] = s
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.signers = mapping
+	return nil
+}
+
+func (c *CSRSigningController) handle(ctx context.Context, csr *capi.CertificateSigningRequest) error {
+	c.lock.RLock()
+	s, ok := c.signers[csr.Spec.SignerName]
+	c.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return s.handle(ctx, csr)
+}
+
+// Sign issues a certificate for an approved request that is not stored in the API server, such as the
+// request of a managed Secret, through the same checks, authorization, verification, and audit as CSRs. It
+// returns the PEM-encoded certificate and CA, or a *RejectionError if the request must not be signed
+func (c *CSRSigningController) Sign(ctx context.Context, csr *capi.CertificateSigningRequest) ([]byte, []byte, error) {
+	c.lock.RLock()
+	s, ok := c.signers[csr.Spec.SignerName]
+	c.lock.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown signer %s", csr.Spec.SignerName)
+	}
+
+	x509cr, err := api.ParseCSR(csr.Spec.Request)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse csr %q: %v", csr.Name, err)
+	}
+	// requests are not retried with the same key, so they are not cached
+	cert, err := s.sign(ctx, csr, x509cr, nil)
+	if errors.Is(err, errNotRecognized) {
+		return nil, nil, &RejectionError{Reason: "SignerValidationFailure", Message: fmt.Sprintf("not recognized by signer %s", s.signerName)}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	caPEM, err := s.ca.certificates()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr = csr.DeepCopy()
+	csr.Status.Certificate = cert
+	if err := s.inventory.Add(csr); err != nil {
+		klog.FromContext(ctx).Error(err, "error adding certificate to inventory", "csr", csr.Name)
+	}
+	return cert, caPEM, nil
+}
+
+func (c *CSRSigningController) getCSR(name string) (*capi.CertificateSigningRequest, error) {
+	return c.certificateController.GetCSR(name)
+}
+
+func (c *CSRSigningController) Run(ctx context.Context, workers int, drainTimeout time.Duration) {
+	c.certificateController.Run(ctx, workers, drainTimeout)
+}
+
+// SignerOptionsFromConfig builds the options of a configured signer
+func SignerOptionsFromConfig(s config.SignerConfig, vsigner signing.Signer) SignerOptions {
+	opts := SignerOptions{
+		Name:          s.Name,
+		Signer:        vsigner,
+		Pki:           s.Pki,
+		Role:          s.Role,
+		CertTTL:       s.SigningDuration.Duration,
+		RequirePolicy: s.Verbatim,
+	}
+	if s.RequesterAuthorization != nil {
+		opts.Authorization = &RequesterAuthorization{SubjectAltNames: s.RequesterAuthorization.SubjectAltNames}
+	}
+	for _, p := range s.Profiles {
+		profile := Profile{
+			Name:                       p.Name,
+			MinDuration:                p.MinDuration.Duration,
+			MaxDuration:                p.MaxDuration.Duration,
+			RequiredSubjectAttributes:  p.RequiredSubjectAttributes,
+			ForbiddenSubjectAttributes: p.ForbiddenSubjectAttributes,
+			CommonName:                 p.CommonName,
+			DNSNames:                   p.DNSNames,
+			URIs:                       p.URIs,
+			IPAddresses:                p.IPAddresses,
+			EmailAddresses:             p.EmailAddresses,
+		}
+		for _, usage := range p.Usages {
+			profile.Usages = append(profile.Usages, capi.KeyUsage(usage))
+		}
+		opts.Profiles = append(opts.Profiles, profile)
+	}
+	if s.SPIFFE != nil {
+		opts.SPIFFE = &SPIFFEProfile{
+			TrustDomain:      s.SPIFFE.TrustDomain,
+			MaxTTL:           s.SPIFFE.MaxTTL.Duration,
+			AllowDNSNames:    s.SPIFFE.AllowDNSSANs,
+			AllowIPAddresses: s.SPIFFE.AllowIPSANs,
+		}
+	}
+	return opts
 }
 
-func (c *CSRSigningController) Run(ctx context.Context, workers int) {
-	c.certificateController.Run(ctx, workers)
+// Check reports whether the signer would issue a certificate for an approved CSR, without signing it.
+// The constraints of the backend are checked only if the options include a signer
+func Check(opts SignerOptions, p *policy.Policy, csr *capi.CertificateSigningRequest) error {
+	s, err := newSigner(nil, opts, p)
+	if err != nil {
+		return err
+	}
+
+	x509cr, err := api.ParseCSR(csr.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("unable to parse csr %q: %v", csr.Name, err)
+	}
+	if csr, err = s.requester(csr); err != nil {
+		return fmt.Errorf("NamespacePolicyViolation: %v", err)
+	}
+	if recognized, reason, message := s.check(csr, x509cr); !recognized {
+		return fmt.Errorf("not recognized by signer %s", s.signerName)
+	} else if reason != "" {
+		return fmt.Errorf("%s: %s", reason, message)
+	}
+	if s.vsigner == nil {
+		return nil
+	}
+
+	usage, extUsages, err := keyUsagesFromStrings(csr.Spec.Usages)
+	if err != nil {
+		return err
+	}
+	return s.vsigner.Validate(x509cr, usage, extUsages, s.ttl(csr))
 }
 
-type isRequestForSignerFunc func(req *x509.CertificateRequest, usages []capi.KeyUsage, signerName string) (bool, error)
+type isRequestForSignerFunc func(csr *capi.CertificateSigningRequest, req *x509.CertificateRequest) (bool, error)
 
 type signer struct {
 	client               clientset.Interface
-	vsigner              *sign.VaultSigner
+	vsigner              signing.Signer
+	ca                   *caBundle
 	certTTL              time.Duration
+	policy               *policy.Policy
 	signerName           string
 	isRequestForSignerFn isRequestForSignerFunc
+	authorization        *RequesterAuthorization
+	requestController    string
+	trustDomain          string
+	profiles             map[string]*compiledProfile
+	pki                  string
+	role                 string
+	audit                audit.Sink
+	cache                *IssuanceCache
+	inventory            *inventory.Inventory
+	getCSR               func(name string) (*capi.CertificateSigningRequest, error)
+}
+
+func newSigner(client clientset.Interface, opts SignerOptions, p *policy.Policy) (*signer, error) {
+	isRequestForSignerFns := []isRequestForSignerFunc{isServiceAccountIdentity}
+	var trustDomain string
+	if opts.SPIFFE != nil {
+		isRequestForSignerFns = append(isRequestForSignerFns, opts.SPIFFE.isRequestForSigner)
+		trustDomain = opts.SPIFFE.TrustDomain
+	}
+
+	profiles := make(map[string]*compiledProfile, len(opts.Profiles))
+	for _, profile := range opts.Profiles {
+		if _, ok := profiles[profile.Name]; ok {
+			return nil, fmt.Errorf("duplicate profile %s for signer %s", profile.Name, opts.Name)
+		}
+		cp, err := compileProfile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %s for signer %s: %v", profile.Name, opts.Name, err)
+		}
+		profiles[profile.Name] = cp
+	}
+
+	return &signer{
+		client:               client,
+		vsigner:              opts.Signer,
+		ca:                   newCABundle(opts.Signer),
+		certTTL:              opts.CertTTL,
+		policy:               p,
+		signerName:           opts.Name,
+		isRequestForSignerFn: allOf(isRequestForSignerFns...),
+		authorization:        opts.Authorization,
+		requestController:    opts.RequestController,
+		trustDomain:          trustDomain,
+		profiles:             profiles,
+		pki:                  opts.Pki,
+		role:                 opts.Role,
+	}, nil
 }
 
 func (s *signer) handle(ctx context.Context, csr *capi.CertificateSigningRequest) error {
@@ -74,39 +314,242 @@ func (s *signer) handle(ctx context.Context, csr *capi.CertificateSigningRequest
 		return nil
 	}
 
+	_, span := tracing.Tracer().Start(ctx, "ParseCSR")
 	x509cr, err := api.ParseCSR(csr.Spec.Request)
+	tracing.End(span, err)
 	if err != nil {
 		return fmt.Errorf("unable to parse csr %q: %v", csr.Name, err)
 	}
-	if recognized, err := s.isRequestForSignerFn(x509cr, csr.Spec.Usages, csr.Spec.SignerName); err != nil {
-		csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
-			Type:           capi.CertificateFailed,
-			Status:         v1.ConditionTrue,
-			Reason:         "SignerValidationFailure",
-			Message:        err.Error(),
-			LastUpdateTime: metav1.Now(),
-		})
-		_, err = s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("error adding failure condition for csr: %v", err)
-		}
-		return nil
-	} else if !recognized {
+	cert, err := s.sign(ctx, csr, x509cr, s.cache)
+	var rerr *RejectionError
+	if errors.Is(err, errNotRecognized) {
 		return nil
+	} else if errors.As(err, &rerr) {
+		return s.fail(ctx, csr, rerr.Reason, rerr.Message)
+	} else if err != nil {
+		return err
 	}
-	cert, err := s.sign(x509cr, csr.Spec.Usages, csr.Spec.ExpirationSeconds, nil)
+	return s.publish(ctx, csr, cert)
+}
+
+// RejectionError reports why the signer refused to issue a certificate for a request
+type RejectionError struct {
+	Reason  string
+	Message string
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+var errNotRecognized = errors.New("request not recognized by the signer")
+
+// sign runs the checks of the signer on an approved request and issues the certificate, recording the outcome
+// in the audit log. It returns the PEM-encoded certificate, or a *RejectionError if the request must not be
+// signed
+func (s *signer) sign(ctx context.Context, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest, cache *IssuanceCache) ([]byte, error) {
+	// the CSRs created for VaultCertificateRequests are checked as requested by the namespace identity
+	requested, err := s.requester(csr)
 	if err != nil {
-		return fmt.Errorf("error auto signing csr: %v", err)
+		return nil, s.reject(ctx, csr, x509cr, "NamespacePolicyViolation", err.Error(), nil)
+	}
+	csr = requested
+
+	_, span := tracing.Tracer().Start(ctx, "EvaluatePolicy")
+	recognized, reason, message := s.check(csr, x509cr)
+	span.SetAttributes(attribute.Bool("recognized", recognized), attribute.String("reason", reason))
+	span.End()
+	if !recognized {
+		return nil, errNotRecognized
+	} else if reason != "" {
+		return nil, s.reject(ctx, csr, x509cr, reason, message, nil)
+	}
+	var uerr *unauthorizedError
+	authCtx, span := tracing.Tracer().Start(ctx, "Authorize")
+	err = s.authorize(authCtx, csr, x509cr)
+	tracing.End(span, err)
+	if errors.As(err, &uerr) {
+		return nil, s.reject(ctx, csr, x509cr, "RequesterNotAuthorized", uerr.Error(), nil)
+	} else if err != nil {
+		return nil, err
+	}
+	var nerr *namespaceError
+	nsPolicy, err := s.namespacePolicy(ctx, csr)
+	if errors.As(err, &nerr) {
+		return nil, s.reject(ctx, csr, x509cr, "NamespacePolicyViolation", nerr.Error(), nil)
+	} else if err != nil {
+		return nil, err
+	} else if nsPolicy != nil {
+		if err := nsPolicy.ValidateRequest(x509cr, csr.Spec.Usages); err != nil {
+			return nil, s.reject(ctx, csr, x509cr, "NamespacePolicyViolation", err.Error(), nil)
+		}
+	}
+	// requests that the backend cannot issue as requested are rejected before contacting it, as retrying would
+	// not change the outcome
+	if usage, extUsages, err := keyUsagesFromStrings(csr.Spec.Usages); err != nil {
+		return nil, s.reject(ctx, csr, x509cr, "SignerValidationFailure", err.Error(), nil)
+	} else if err := s.vsigner.Validate(x509cr, usage, extUsages, s.ttl(csr)); err != nil {
+		return nil, s.reject(ctx, csr, x509cr, "SignerValidationFailure", err.Error(), nil)
+	}
+	// a certificate issued by a previous sync that failed afterwards is reused instead of signing a new one,
+	// so it is cached as soon as the backend returns it
+	issuance, ok := cache.Get(csr)
+	if ok {
+		klog.FromContext(ctx).V(2).Info("reusing cached certificate", "csr", csr.Name)
+	} else {
+		now := time.Now()
+		chain, err := issue(ctx, s.vsigner, x509cr, csr.Spec.Usages, s.ttl(csr))
+		var oerr *signing.CircuitOpenError
+		if errors.As(err, &oerr) {
+			return nil, oerr
+		} else if err != nil {
+			return nil, fmt.Errorf("error auto signing csr: %v", err)
+		}
+		issuance = cache.Put(csr, encodeCertificates(chain), now)
+	}
+
+	chain, err := api.ParseCertificates(issuance.Chain)
+	if err != nil || len(chain) == 0 {
+		return nil, fmt.Errorf("unable to parse issued certificate chain: %v", err)
+	}
+	cert := encodeCertificates(chain[:1])
+	var verr *VerificationError
+	if err := verifyIssued(s.ca, chain, x509cr, csr.Spec.Usages, s.ttl(csr), issuance.Issued); errors.As(err, &verr) {
+		// the issued certificate is recorded, as it exists even if it is not handed out
+		return nil, s.reject(ctx, csr, x509cr, "CertificateVerificationFailure", verr.Error(), cert)
+	} else if err != nil {
+		return nil, err
+	}
+	if nsPolicy != nil {
+		if err := nsPolicy.ValidateCertificate(chain[0]); err != nil {
+			return nil, s.reject(ctx, csr, x509cr, "NamespacePolicyViolation", err.Error(), cert)
+		}
+	}
+	// the certificate is handed out only once it is recorded
+	if !issuance.Recorded {
+		if err := s.record(ctx, csr, x509cr, audit.Issued, "", "", cert); err != nil {
+			return nil, err
+		}
+		cache.MarkRecorded(csr)
 	}
+	return cert, nil
+}
+
+// reject records the rejection of the request, returning it as a *RejectionError
+func (s *signer) reject(ctx context.Context, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest, reason, message string, cert []byte) error {
+	if err := s.record(ctx, csr, x509cr, audit.Rejected, reason, message, cert); err != nil {
+		return err
+	}
+	return &RejectionError{Reason: reason, Message: message}
+}
+
+// publish sets the certificate in the CSR status, retrying conflicts with the latest version of the CSR
+func (s *signer) publish(ctx context.Context, csr *capi.CertificateSigningRequest, cert []byte) error {
 	csr.Status.Certificate = cert
-	_, err = s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	published := false
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		err := s.updateStatus(ctx, csr)
+		if !apierrors.IsConflict(err) {
+			published = err == nil
+			return err
+		}
+		latest, gerr := s.getCSR(csr.Name)
+		if apierrors.IsNotFound(gerr) {
+			return nil
+		} else if gerr != nil {
+			return gerr
+		}
+		if latest.UID != csr.UID || len(latest.Status.Certificate) > 0 || controller.HasTrueCondition(latest, capi.CertificateFailed) {
+			// the CSR was replaced or already completed
+			return nil
+		}
+		csr = latest.DeepCopy()
+		csr.Status.Certificate = cert
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("error updating signature for csr: %v", err)
 	}
+	if published {
+		if err := s.inventory.Add(csr); err != nil {
+			klog.FromContext(ctx).Error(err, "error adding certificate to inventory", "csr", csr.Name)
+		}
+	}
 	return nil
 }
 
-func (s *signer) sign(x509cr *x509.CertificateRequest, usages []capi.KeyUsage, expirationSeconds *int32, now func() time.Time) ([]byte, error) {
+// check runs the signer validation, the profile, and the signing policy, returning the failure reason and message if
+// the request must not be signed
+func (s *signer) check(csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) (recognized bool, reason, message string) {
+	if recognized, err := s.isRequestForSignerFn(csr, x509cr); err != nil {
+		return true, "SignerValidationFailure", err.Error()
+	} else if !recognized {
+		return false, "", ""
+	}
+	if p, err := s.profile(csr); err != nil {
+		return true, "ProfileViolation", err.Error()
+	} else if p != nil {
+		if err := p.validate(csr, x509cr); err != nil {
+			return true, "ProfileViolation", err.Error()
+		}
+	}
+	switch result := s.policy.Evaluate(csr, x509cr); result.Decision {
+	case policy.Deny:
+		return true, "SigningPolicyDenied", result.Message
+	case policy.Fail:
+		return true, "SigningPolicyFailure", result.Message
+	}
+	return true, "", ""
+}
+
+// fail marks the CSR as Failed
+func (s *signer) fail(ctx context.Context, csr *capi.CertificateSigningRequest, reason, message string) error {
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:           capi.CertificateFailed,
+		Status:         v1.ConditionTrue,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: metav1.Now(),
+	})
+	if err := s.updateStatus(ctx, csr); err != nil {
+		return fmt.Errorf("error adding failure condition for csr: %v", err)
+	}
+	return nil
+}
+
+func (s *signer) updateStatus(ctx context.Context, csr *capi.CertificateSigningRequest) error {
+	ctx, span := tracing.Tracer().Start(ctx, "UpdateStatus")
+	_, err := s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	tracing.End(span, err)
+	return err
+}
+
+// ttl returns the duration of the certificate, capped by the profile selected by the CSR
+func (s *signer) ttl(csr *capi.CertificateSigningRequest) time.Duration {
+	ttl := s.duration(csr.Spec.ExpirationSeconds)
+	if p, _ := s.profile(csr); p != nil && p.MaxDuration > 0 && ttl > p.MaxDuration {
+		return p.MaxDuration
+	}
+	return ttl
+}
+
+// SignRequest validates a certificate request and signs it, returning the PEM-encoded certificate
+func SignRequest(ctx context.Context, vsigner signing.Signer, x509cr *x509.CertificateRequest, usages []capi.KeyUsage, ttl time.Duration) ([]byte, error) {
+	now := time.Now()
+	chain, err := issue(ctx, vsigner, x509cr, usages, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyIssued(newCABundle(vsigner), chain, x509cr, usages, ttl, now); err != nil {
+		return nil, err
+	}
+	return encodeCertificates(chain[:1]), nil
+}
+
+// issue signs a certificate request, returning the issued certificate followed by the CA chain
+func issue(ctx context.Context, vsigner signing.Signer, x509cr *x509.CertificateRequest, usages []capi.KeyUsage, ttl time.Duration) (_ []*x509.Certificate, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Sign", trace.WithAttributes(attribute.String("ttl", ttl.String())))
+	defer func() { tracing.End(span, err) }()
 
 	cr, err := x509.ParseCertificateRequest(x509cr.Raw)
 	if err != nil {
@@ -120,39 +563,107 @@ func (s *signer) sign(x509cr *x509.CertificateRequest, usages []capi.KeyUsage, e
 	if err != nil {
 		return nil, err
 	}
+	chain, err := vsigner.Sign(ctx, cr, usage, extUsages, ttl)
+	if err == nil && len(chain) == 0 {
+		err = fmt.Errorf("no certificate returned by the signer")
+	}
+	return chain, err
+}
 
-	cert, err := s.vsigner.Sign(cr, usage, extUsages, s.certTTL)
+// verifyIssued checks the chain issued at the given time against the request
+func verifyIssued(ca *caBundle, chain []*x509.Certificate, x509cr *x509.CertificateRequest, usages []capi.KeyUsage, ttl time.Duration, issued time.Time) error {
+	usage, extUsages, err := keyUsagesFromStrings(usages)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return verifyCertificate(ca, chain, x509cr, usage, extUsages, ttl, issued)
+}
 
-	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), nil
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
 }
 
 func (s *signer) duration(expirationSeconds *int32) time.Duration {
+	return Duration(s.certTTL, expirationSeconds)
+}
+
+// Duration returns the requested certificate duration, capped to the configured one and to a minimum of 10 minutes
+func Duration(certTTL time.Duration, expirationSeconds *int32) time.Duration {
 	if expirationSeconds == nil {
-		return s.certTTL
+		return certTTL
 	}
 
-	const minimum = 10 * time.Minute
 	switch requestedDuration := csr.ExpirationSecondsToDuration(*expirationSeconds); {
-	case requestedDuration > s.certTTL:
-		return s.certTTL
+	case requestedDuration > certTTL:
+		return certTTL
 
-	case requestedDuration < minimum:
-		return minimum
+	case requestedDuration < config.MinCertificateDuration:
+		return config.MinCertificateDuration
 	default:
 		return requestedDuration
 	}
 }
 
-func isVaultSigner(req *x509.CertificateRequest, usages []capi.KeyUsage, signerName string) (bool, error) {
-	if signerName != api.VaultSignerName {
-		return false, nil
+func allOf(fns ...isRequestForSignerFunc) isRequestForSignerFunc {
+	return func(csr *capi.CertificateSigningRequest, req *x509.CertificateRequest) (bool, error) {
+		for _, fn := range fns {
+			if recognized, err := fn(csr, req); err != nil || !recognized {
+				return recognized, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// isServiceAccountIdentity ensures that only a ServiceAccount can request a certificate for its own identity. URIs
+// with the /ns/<namespace>/sa/<name> path of SPIFFE IDs identify a ServiceAccount in all CSRs, while the certificates
+// with the username of a ServiceAccount as common name may only contain its URI and the DNS names of its namespace
+func isServiceAccountIdentity(csr *capi.CertificateSigningRequest, req *x509.CertificateRequest) (bool, error) {
+	for _, uri := range req.URIs {
+		if username, ok := serviceAccountOfURI(uri); ok && username != csr.Spec.Username {
+			return false, fmt.Errorf("URI SAN %q identifies %q, not the requesting user %q", uri, username, csr.Spec.Username)
+		}
+	}
+
+	if !strings.HasPrefix(req.Subject.CommonName, api.ServiceAccountUsernamePrefix) {
+		return true, nil
+	}
+	if req.Subject.CommonName != csr.Spec.Username {
+		return false, fmt.Errorf("common name %q does not match the requesting user %q", req.Subject.CommonName, csr.Spec.Username)
+	}
+	namespace, _, _ := strings.Cut(strings.TrimPrefix(csr.Spec.Username, api.ServiceAccountUsernamePrefix), ":")
+	for _, uri := range req.URIs {
+		if _, ok := serviceAccountOfURI(uri); !ok {
+			return false, fmt.Errorf("URI SAN %q is not the identity of the requesting ServiceAccount", uri)
+		}
+	}
+	for _, name := range req.DNSNames {
+		if !strings.HasSuffix(name, "."+namespace+".svc") && !strings.HasSuffix(name, "."+namespace+".svc.cluster.local") {
+			return false, fmt.Errorf("DNS SAN %q is not a Service name of namespace %s", name, namespace)
+		}
+	}
+	if len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 {
+		return false, fmt.Errorf("IP and email SANs are not allowed in ServiceAccount certificates")
 	}
 	return true, nil
 }
 
+// serviceAccountOfURI returns the username of the ServiceAccount identified by the path of a SPIFFE ID
+func serviceAccountOfURI(uri *url.URL) (string, bool) {
+	if uri.Scheme != "spiffe" {
+		return "", false
+	}
+	parts := strings.Split(uri.Path, "/")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "ns" || parts[2] == "" || parts[3] != "sa" || parts[4] == "" {
+		return "", false
+	}
+	return api.ServiceAccountUsernamePrefix + parts[2] + ":" + parts[4], true
+}
+
 var keyUsageDict = map[capi.KeyUsage]x509.KeyUsage{
 	capi.UsageSigning:           x509.KeyUsageDigitalSignature,
 	capi.UsageDigitalSignature:  x509.KeyUsageDigitalSignature,
@@ -0,0 +1,859 @@
+package signer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/audit"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/inventory"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/vault/sign"
+	vault "github.com/hashicorp/vault/api"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+const testSignerName = "unito.it/vault-signer"
+
+// newTestCA returns a LocalSigner backed by a freshly generated CA, and the CA certificate
+func newTestCA(t *testing.T) (*signing.LocalSigner, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := signing.NewLocalSigner(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, ca
+}
+
+func newTestCSR(t *testing.T, name, commonName string, conditions ...capi.CertificateSigningRequestCondition) *capi.CertificateSigningRequest {
+	t.Helper()
+	return newTestCSRFor(t, name, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: []string{commonName + ".example.com"},
+	}, conditions...)
+}
+
+func newTestCSRFor(t *testing.T, name string, template *x509.CertificateRequest, conditions ...capi.CertificateSigningRequestCondition) *capi.CertificateSigningRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + name)},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			SignerName: testSignerName,
+			Username:   "alice",
+			Usages:     []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageClientAuth},
+		},
+		Status: capi.CertificateSigningRequestStatus{Conditions: conditions},
+	}
+}
+
+func condition(conditionType capi.RequestConditionType) capi.CertificateSigningRequestCondition {
+	return capi.CertificateSigningRequestCondition{Type: conditionType, Status: v1.ConditionTrue, Reason: "Test"}
+}
+
+func TestCSRSigningController(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []policy.Rule
+		csr     func(t *testing.T) *capi.CertificateSigningRequest
+		issued  bool
+		failure string
+	}{
+		{
+			name: "approved",
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "approved", "app", condition(capi.CertificateApproved))
+			},
+			issued: true,
+		},
+		{
+			name: "approved and allowed by policy",
+			rules: []policy.Rule{
+				{Name: "dns", Expression: `x509.dnsNames.all(n, n.endsWith(".example.com"))`},
+			},
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "allowed", "app", condition(capi.CertificateApproved))
+			},
+			issued: true,
+		},
+		{
+			name: "denied",
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "denied", "app", condition(capi.CertificateDenied))
+			},
+		},
+		{
+			name: "pending",
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "pending", "app")
+			},
+		},
+		{
+			name: "denied by policy",
+			rules: []policy.Rule{
+				{Name: "cn", Expression: `x509.subject.commonName.startsWith("allowed-")`, Message: "common name must start with allowed-"},
+			},
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "policy-denied", "app", condition(capi.CertificateApproved))
+			},
+			failure: "SigningPolicyDenied",
+		},
+		{
+			name: "policy failure",
+			rules: []policy.Rule{
+				{Name: "extra", Expression: `extra["missing"][0] == "value"`},
+			},
+			csr: func(t *testing.T) *capi.CertificateSigningRequest {
+				return newTestCSR(t, "policy-failure", "app", condition(capi.CertificateApproved))
+			},
+			failure: "SigningPolicyFailure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			local, ca := newTestCA(t)
+			p, err := policy.NewPolicy(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr := tt.csr(t)
+			client := startController(ctx, t, csr, SignerOptions{Name: testSignerName, Signer: local, CertTTL: time.Hour}, p, nil, nil)
+
+			timeout := 10 * time.Second
+			if !tt.issued && tt.failure == "" {
+				// nothing must happen, so the controller is given some time to act wrongly
+				timeout = 500 * time.Millisecond
+			}
+			got := waitForCSR(ctx, t, client, csr.Name, timeout)
+
+			switch {
+			case tt.issued:
+				checkIssued(t, got, ca)
+			case tt.failure != "":
+				if len(got.Status.Certificate) > 0 {
+					t.Fatal("expected no certificate")
+				}
+				failed := getCondition(got, capi.CertificateFailed)
+				if failed == nil || failed.Reason != tt.failure {
+					t.Fatalf("expected failed condition with reason %s, got %+v", tt.failure, got.Status.Conditions)
+				}
+			default:
+				if len(got.Status.Certificate) > 0 || hasCondition(got, capi.CertificateFailed) {
+					t.Fatalf("expected csr to be left untouched, got %+v", got.Status)
+				}
+			}
+		})
+	}
+}
+
+// startController runs a signing controller for a single signer, returning the fake client holding the CSR
+func startController(ctx context.Context, t *testing.T, csr *capi.CertificateSigningRequest, opts SignerOptions, p *policy.Policy, sink audit.Sink, cache *IssuanceCache, objects ...runtime.Object) *fake.Clientset {
+	t.Helper()
+	client := fake.NewSimpleClientset(append(objects, csr)...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	controller, err := NewVaultCSRSigningController(
+		ctx,
+		client,
+		[]certificatesinformers.CertificateSigningRequestInformer{factory.Certificates().V1().CertificateSigningRequests()},
+		[]SignerOptions{opts},
+		map[string]*policy.Policy{opts.Name: p},
+		sink,
+		cache,
+		nil,
+		workqueue.NewItemExponentialFailureRateLimiter(10*time.Millisecond, 100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory.Start(ctx.Done())
+	go controller.Run(ctx, 1, time.Second)
+	return client
+}
+
+// waitForCSR returns the CSR once it has a certificate or a failed condition, or after the timeout
+func waitForCSR(ctx context.Context, t *testing.T, client *fake.Clientset, name string, timeout time.Duration) *capi.CertificateSigningRequest {
+	t.Helper()
+	var got *capi.CertificateSigningRequest
+	var err error
+	_ = wait.PollUntilContextTimeout(ctx, 20*time.Millisecond, timeout, true, func(ctx context.Context) (bool, error) {
+		got, err = client.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return len(got.Status.Certificate) > 0 || hasCondition(got, capi.CertificateFailed), nil
+	})
+	if got == nil {
+		t.Fatalf("unable to get csr: %v", err)
+	}
+	return got
+}
+
+// countingSigner counts the calls to the backend
+type countingSigner struct {
+	signing.Signer
+	calls atomic.Int32
+}
+
+func (s *countingSigner) Sign(ctx context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error) {
+	s.calls.Add(1)
+	return s.Signer.Sign(ctx, csr, usage, extUsages, ttl)
+}
+
+// flakySink fails the first writes
+type flakySink struct {
+	failures atomic.Int32
+	records  atomic.Int32
+}
+
+func (s *flakySink) Write(_ context.Context, _ *audit.Record) error {
+	if s.failures.Add(-1) >= 0 {
+		return errors.New("audit log unavailable")
+	}
+	s.records.Add(1)
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+func TestIssuanceCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache bool
+		calls int32
+	}{
+		{name: "cached", cache: true, calls: 1},
+		{name: "not cached", cache: false, calls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			local, ca := newTestCA(t)
+			vsigner := &countingSigner{Signer: local}
+			sink := &flakySink{}
+			sink.failures.Store(1)
+			var cache *IssuanceCache
+			if tt.cache {
+				var err error
+				if cache, err = NewIssuanceCache(time.Hour, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			csr := newTestCSR(t, "flaky-audit", "app", condition(capi.CertificateApproved))
+			client := startController(ctx, t, csr, SignerOptions{Name: testSignerName, Signer: vsigner, CertTTL: time.Hour}, nil, sink, cache)
+			checkIssued(t, waitForCSR(ctx, t, client, csr.Name, 10*time.Second), ca)
+
+			if got := vsigner.calls.Load(); got != tt.calls {
+				t.Errorf("expected %d sign calls, got %d", tt.calls, got)
+			}
+			if got := sink.records.Load(); got != 1 {
+				t.Errorf("expected 1 audit record, got %d", got)
+			}
+		})
+	}
+}
+
+func checkIssued(t *testing.T, csr *capi.CertificateSigningRequest, ca *x509.Certificate) {
+	t.Helper()
+	block, _ := pem.Decode(csr.Status.Certificate)
+	if block == nil {
+		t.Fatalf("expected an issued certificate, got status %+v", csr.Status)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("issued certificate does not chain to the CA: %v", err)
+	}
+	if cert.Subject.CommonName != "app" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "app.example.com" {
+		t.Errorf("unexpected subject %s and DNS names %v", cert.Subject, cert.DNSNames)
+	}
+	if lifetime := cert.NotAfter.Sub(time.Now()); lifetime > time.Hour+time.Minute {
+		t.Errorf("certificate lifetime %s exceeds the signer ttl", lifetime)
+	}
+}
+
+func getCondition(csr *capi.CertificateSigningRequest, conditionType capi.RequestConditionType) *capi.CertificateSigningRequestCondition {
+	for i := range csr.Status.Conditions {
+		if csr.Status.Conditions[i].Type == conditionType {
+			return &csr.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func hasCondition(csr *capi.CertificateSigningRequest, conditionType capi.RequestConditionType) bool {
+	return getCondition(csr, conditionType) != nil
+}
+
+// rotatingSigner serves the CA of the current signer, counting the reads
+type rotatingSigner struct {
+	signing.Signer
+	reads atomic.Int32
+	err   error
+}
+
+func (s *rotatingSigner) CA() ([]byte, error) {
+	s.reads.Add(1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Signer.CA()
+}
+
+func TestCABundle(t *testing.T) {
+	old, _ := newTestCA(t)
+	current, _ := newTestCA(t)
+	csr := newTestCSR(t, "rotated", "app")
+	block, _ := pem.Decode(csr.Spec.Request)
+	x509cr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(s signing.Signer) []*x509.Certificate {
+		chain, err := s.Sign(context.Background(), x509cr, x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chain
+	}
+
+	vsigner := &rotatingSigner{Signer: old}
+	ca := newCABundle(vsigner)
+	for i := 0; i < 3; i++ {
+		if err := verifyChain(ca, sign(old)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := vsigner.reads.Load(); got != 1 {
+		t.Errorf("expected the CA to be read once, got %d", got)
+	}
+
+	// a certificate issued by a rotated CA refreshes the bundle
+	vsigner.Signer = current
+	if err := verifyChain(ca, sign(current)); err != nil {
+		t.Fatalf("unexpected error after rotation: %v", err)
+	}
+	if got := vsigner.reads.Load(); got != 2 {
+		t.Errorf("expected the CA to be refreshed once, got %d reads", got)
+	}
+
+	var verr *VerificationError
+	if err := verifyChain(ca, sign(old)); !errors.As(err, &verr) {
+		t.Errorf("expected a verification error for a foreign CA, got %v", err)
+	}
+
+	// failures to read the CA are not verification errors, so the CSR is retried rather than failed
+	vsigner.err = errors.New("vault unavailable")
+	if err := verifyChain(newCABundle(vsigner), sign(current)); err == nil || errors.As(err, &verr) {
+		t.Errorf("expected a transient error, got %v", err)
+	}
+}
+
+// recordingSink keeps the written records
+type recordingSink struct {
+	lock    sync.Mutex
+	records []audit.Record
+}
+
+func (s *recordingSink) Write(_ context.Context, r *audit.Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records = append(s.records, *r)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) get() []audit.Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.records)
+}
+
+// longLivedSigner issues certificates outliving the requested duration
+type longLivedSigner struct {
+	signing.Signer
+}
+
+func (s *longLivedSigner) Sign(ctx context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error) {
+	return s.Signer.Sign(ctx, csr, usage, extUsages, 2*ttl)
+}
+
+func TestAuditVerificationFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, _ := newTestCA(t)
+	sink := &recordingSink{}
+	csr := newTestCSR(t, "long-lived", "app", condition(capi.CertificateApproved))
+	client := startController(ctx, t, csr, SignerOptions{Name: testSignerName, Signer: &longLivedSigner{Signer: local}, CertTTL: time.Hour}, nil, sink, nil)
+
+	got := waitForCSR(ctx, t, client, csr.Name, 10*time.Second)
+	if failed := getCondition(got, capi.CertificateFailed); failed == nil || failed.Reason != "CertificateVerificationFailure" {
+		t.Fatalf("expected verification failure, got %+v", got.Status)
+	}
+	records := sink.get()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(records))
+	}
+	if r := records[0]; r.Outcome != audit.Rejected || r.Serial == "" || r.NotAfter == nil {
+		t.Errorf("expected a rejection recording the issued certificate, got %+v", r)
+	}
+}
+
+func TestSign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, ca := newTestCA(t)
+	sink := &recordingSink{}
+	inv := inventory.New(nil)
+	controller, err := NewVaultCSRSigningController(
+		ctx,
+		fake.NewSimpleClientset(),
+		nil,
+		[]SignerOptions{{Name: testSignerName, Signer: local, CertTTL: time.Hour}},
+		nil,
+		sink,
+		nil,
+		inv,
+		workqueue.DefaultControllerRateLimiter(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr := newTestCSR(t, "default/app-tls", "app", condition(capi.CertificateApproved))
+	cert, caPEM, err := controller.Sign(ctx, csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr.Status.Certificate = cert
+	checkIssued(t, csr, ca)
+	if cas, err := api.ParseCertificates(caPEM); err != nil || !cas[0].Equal(ca) {
+		t.Errorf("expected the signer CA, got %v", err)
+	}
+	if records := sink.get(); len(records) != 1 || records[0].Outcome != audit.Issued || records[0].CSR != "default/app-tls" {
+		t.Errorf("expected the certificate to be recorded, got %+v", records)
+	}
+	if entries := inv.Query(inventory.Query{}); len(entries) != 1 {
+		t.Errorf("expected the certificate in the inventory, got %+v", entries)
+	}
+
+	var rerr *RejectionError
+	csr = newTestCSR(t, "default/other-tls", "system:serviceaccount:default:other", condition(capi.CertificateApproved))
+	if _, _, err := controller.Sign(ctx, csr); !errors.As(err, &rerr) {
+		t.Errorf("expected a rejection, got %v", err)
+	}
+}
+
+func TestNamespacePolicy(t *testing.T) {
+	const controller = "system:serviceaccount:vault-signer:vault-signer"
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}}
+	tests := []struct {
+		name      string
+		namespace string
+		username  string
+		dnsName   string
+		issued    bool
+	}{
+		{name: "allowed", namespace: "team", username: controller, dnsName: "app.team.svc", issued: true},
+		{name: "foreign name", namespace: "team", username: controller, dnsName: "app.other.svc"},
+		{name: "unknown namespace", namespace: "other", username: controller, dnsName: "app.other.svc"},
+		{name: "controller without label", username: controller, dnsName: "app.other.svc"},
+		{name: "other requester without label", username: "alice", dnsName: "app.other.svc", issued: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			local, _ := newTestCA(t)
+			csr := newTestCSRFor(t, "namespaced", &x509.CertificateRequest{DNSNames: []string{tt.dnsName}}, condition(capi.CertificateApproved))
+			csr.Spec.Username = tt.username
+			if tt.namespace != "" {
+				csr.Labels = map[string]string{v1alpha1.RequestNamespaceLabel: tt.namespace, v1alpha1.RequestNameLabel: "app"}
+				csr.Annotations = map[string]string{v1alpha1.RequesterAnnotation: policy.RequesterUsername(tt.namespace, "app")}
+			}
+			opts := SignerOptions{Name: testSignerName, Signer: local, CertTTL: time.Hour, RequestController: controller}
+			client := startController(ctx, t, csr, opts, nil, nil, nil, namespace)
+
+			got := waitForCSR(ctx, t, client, csr.Name, 10*time.Second)
+			if tt.issued && len(got.Status.Certificate) == 0 {
+				t.Fatalf("expected an issued certificate, got %+v", got.Status)
+			}
+			if failed := getCondition(got, capi.CertificateFailed); !tt.issued && (failed == nil || failed.Reason != "NamespacePolicyViolation") {
+				t.Fatalf("expected a namespace policy violation, got %+v", got.Status)
+			}
+		})
+	}
+}
+
+func TestRequestControllerRequester(t *testing.T) {
+	const controller = "system:serviceaccount:vault-signer:vault-signer"
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}}
+	tests := []struct {
+		name      string
+		requester string
+		reason    string
+	}{
+		{name: "namespace requester", requester: "system:vault:team:app", reason: "RequesterNotAuthorized"},
+		{name: "foreign requester", requester: "system:vault:other:app", reason: "NamespacePolicyViolation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			local, _ := newTestCA(t)
+			sink := &recordingSink{}
+			csr := newTestCSRFor(t, "namespaced", &x509.CertificateRequest{DNSNames: []string{"app.team.svc"}}, condition(capi.CertificateApproved))
+			csr.Spec.Username = controller
+			csr.Spec.Groups = []string{"system:serviceaccounts", "system:authenticated"}
+			csr.Labels = map[string]string{v1alpha1.RequestNamespaceLabel: "team", v1alpha1.RequestNameLabel: "app"}
+			csr.Annotations = map[string]string{v1alpha1.RequesterAnnotation: tt.requester}
+			opts := SignerOptions{
+				Name:              testSignerName,
+				Signer:            local,
+				CertTTL:           time.Hour,
+				RequestController: controller,
+				Authorization:     &RequesterAuthorization{},
+			}
+			// the fake client denies all SubjectAccessReviews, reporting the reviewed user in the failure
+			client := startController(ctx, t, csr, opts, nil, sink, nil, namespace)
+
+			got := waitForCSR(ctx, t, client, csr.Name, 10*time.Second)
+			failed := getCondition(got, capi.CertificateFailed)
+			if failed == nil || failed.Reason != tt.reason {
+				t.Fatalf("expected a failure with reason %s, got %+v", tt.reason, got.Status)
+			}
+			records := sink.get()
+			if len(records) != 1 {
+				t.Fatalf("expected 1 audit record, got %d", len(records))
+			}
+			if tt.reason == "RequesterNotAuthorized" {
+				if !strings.Contains(failed.Message, `"system:vault:team:app"`) {
+					t.Errorf("expected the namespace requester to be authorized, got %q", failed.Message)
+				}
+				if r := records[0].Requester; r.Username != "system:vault:team:app" || !slices.Equal(r.Groups, policy.RequesterGroups("team")) {
+					t.Errorf("expected the namespace requester to be recorded, got %+v", r)
+				}
+			}
+		})
+	}
+}
+
+// vaultKeyUsages maps the key usages of Vault roles to the issued ones
+var vaultKeyUsages = map[string]x509.KeyUsage{
+	"DigitalSignature": x509.KeyUsageDigitalSignature,
+	"KeyAgreement":     x509.KeyUsageKeyAgreement,
+	"KeyEncipherment":  x509.KeyUsageKeyEncipherment,
+	"DataEncipherment": x509.KeyUsageDataEncipherment,
+}
+
+// newVaultRole returns the fields of a Vault PKI role with the given parameters, filling the others with the
+// Vault defaults
+func newVaultRole(params map[string]string) map[string]interface{} {
+	role := map[string]interface{}{
+		"key_usage":             []string{"DigitalSignature", "KeyAgreement", "KeyEncipherment"},
+		"ext_key_usage":         []string{},
+		"server_flag":           true,
+		"client_flag":           true,
+		"code_signing_flag":     false,
+		"email_protection_flag": false,
+		"max_ttl":               0,
+	}
+	for k, v := range params {
+		switch k {
+		case "key_usage":
+			role[k] = strings.Split(v, ",")
+		case "server_flag", "client_flag":
+			role[k] = v == "true"
+		case "max_ttl":
+			d, _ := time.ParseDuration(v)
+			role[k] = int(d.Seconds())
+		}
+	}
+	return role
+}
+
+// newFakeVault serves the role, sign, and CA endpoints of a Vault PKI mount. As the sign endpoint does, it
+// issues certificates with the usages of the role, whatever the request asks for
+func newFakeVault(t *testing.T, pki, name string, role map[string]interface{}) *vault.Client {
+	t.Helper()
+	local, _ := newTestCA(t)
+	var usage x509.KeyUsage
+	for _, u := range role["key_usage"].([]string) {
+		usage |= vaultKeyUsages[u]
+	}
+	var extUsages []x509.ExtKeyUsage
+	if role["server_flag"].(bool) {
+		extUsages = append(extUsages, x509.ExtKeyUsageServerAuth)
+	}
+	if role["client_flag"].(bool) {
+		extUsages = append(extUsages, x509.ExtKeyUsageClientAuth)
+	}
+
+	respond := func(w http.ResponseWriter, data map[string]interface{}) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/v1/%s/roles/%s", pki, name), func(w http.ResponseWriter, r *http.Request) {
+		respond(w, role)
+	})
+	mux.HandleFunc(fmt.Sprintf("/v1/%s/cert/ca", pki), func(w http.ResponseWriter, r *http.Request) {
+		ca, _ := local.CA()
+		respond(w, map[string]interface{}{"certificate": string(ca)})
+	})
+	mux.HandleFunc(fmt.Sprintf("/v1/%s/sign/%s", pki, name), func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			CSR string `json:"csr"`
+			TTL string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		block, _ := pem.Decode([]byte(params.CSR))
+		ttl, err := time.ParseDuration(params.TTL)
+		if block == nil || err != nil {
+			http.Error(w, "invalid csr or ttl", http.StatusBadRequest)
+			return
+		}
+		cr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chain, err := local.Sign(r.Context(), cr, usage, extUsages, ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respond(w, map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0].Raw})),
+			"ca_chain":    []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[1].Raw}))},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = server.URL
+	client, err := vault.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("test")
+	return client
+}
+
+// readmeBlock returns the first code block of the README following the given text
+func readmeBlock(t *testing.T, after string) string {
+	t.Helper()
+	readme, err := os.ReadFile("../../../../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rest, ok := strings.Cut(string(readme), after)
+	if !ok {
+		t.Fatalf("%q not found in the README", after)
+	}
+	_, rest, _ = strings.Cut(rest, "```")
+	_, rest, _ = strings.Cut(rest, "\n")
+	block, _, ok := strings.Cut(rest, "```")
+	if !ok {
+		t.Fatalf("no code block after %q in the README", after)
+	}
+	return block
+}
+
+// TestREADME runs the quickstart of the README: the CSR is signed with the Vault role it describes
+func TestREADME(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := make(map[string]string)
+	for _, field := range strings.Fields(readmeBlock(t, "create a role for the Kubernetes signer")) {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			params[k] = strings.Trim(v, `"`)
+		}
+	}
+	vclient := newFakeVault(t, "pki", "kubernetes-signer", newVaultRole(params))
+	vsigner, err := sign.NewSigner(vclient, "pki", "kubernetes-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr := &capi.CertificateSigningRequest{}
+	if err := yaml.UnmarshalStrict([]byte(readmeBlock(t, "create a `csr.yaml` file")), csr); err != nil {
+		t.Fatal(err)
+	}
+	csr.Spec.Username = "kubernetes-admin"
+	csr.Status.Conditions = append(csr.Status.Conditions, condition(capi.CertificateApproved))
+	client := startController(ctx, t, csr, SignerOptions{Name: csr.Spec.SignerName, Signer: vsigner, CertTTL: 24 * time.Hour}, nil, nil, nil)
+
+	got := waitForCSR(ctx, t, client, csr.Name, 10*time.Second)
+	if len(got.Status.Certificate) == 0 {
+		t.Fatalf("expected an issued certificate, got %+v", got.Status)
+	}
+}
+
+func TestVerifyUsages(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   map[string]string
+		issued bool
+	}{
+		{name: "requested usages", role: map[string]string{"key_usage": "DigitalSignature", "server_flag": "false"}, issued: true},
+		{name: "role issuing more usages", role: map[string]string{"key_usage": "DigitalSignature"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tt.role["max_ttl"] = "24h"
+			vclient := newFakeVault(t, "pki", "kubernetes-signer", newVaultRole(tt.role))
+			vsigner, err := sign.NewSigner(vclient, "pki", "kubernetes-signer")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the CSR requests the digital signature and client auth usages
+			csr := newTestCSR(t, "usages", "app", condition(capi.CertificateApproved))
+			client := startController(ctx, t, csr, SignerOptions{Name: testSignerName, Signer: vsigner, CertTTL: time.Hour}, nil, nil, nil)
+
+			got := waitForCSR(ctx, t, client, csr.Name, 10*time.Second)
+			if tt.issued && len(got.Status.Certificate) == 0 {
+				t.Fatalf("expected an issued certificate, got %+v", got.Status)
+			}
+			if failed := getCondition(got, capi.CertificateFailed); !tt.issued && (failed == nil || failed.Reason != "CertificateVerificationFailure") {
+				t.Fatalf("expected a verification failure, got %+v", got.Status)
+			}
+		})
+	}
+}
+
+func TestServiceAccountIdentity(t *testing.T) {
+	const requester = "system:serviceaccount:team:app"
+	parseURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tests := []struct {
+		name    string
+		request *x509.CertificateRequest
+		allowed bool
+	}{
+		{name: "other identity", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "app"}, DNSNames: []string{"app.example.com"}}, allowed: true},
+		{name: "own identity", request: &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: requester},
+			DNSNames: []string{"app.team.svc", "app.team.svc.cluster.local"},
+			URIs:     []*url.URL{parseURL("spiffe://cluster.local/ns/team/sa/app")},
+		}, allowed: true},
+		{name: "foreign common name", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:serviceaccount:team:other"}}},
+		{name: "foreign URI", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "app"}, URIs: []*url.URL{parseURL("spiffe://cluster.local/ns/team/sa/other")}}},
+		{name: "foreign DNS name", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: requester}, DNSNames: []string{"app.other.svc"}}},
+		{name: "other URI", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: requester}, URIs: []*url.URL{parseURL("https://app.example.com")}}},
+		{name: "IP address", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: requester}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := &capi.CertificateSigningRequest{Spec: capi.CertificateSigningRequestSpec{Username: requester}}
+			ok, err := isServiceAccountIdentity(csr, tt.request)
+			if ok != tt.allowed || (err == nil) != tt.allowed {
+				t.Errorf("expected allowed %t, got %t (%v)", tt.allowed, ok, err)
+			}
+		})
+	}
+}
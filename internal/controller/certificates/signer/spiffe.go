@@ -0,0 +1,58 @@
+package signer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/util/certificate/csr"
+)
+
+// SPIFFEProfile restricts the signer to the issuance of SPIFFE X.509-SVIDs for Kubernetes ServiceAccounts
+type SPIFFEProfile struct {
+	TrustDomain      string
+	MaxTTL           time.Duration
+	AllowDNSNames    bool
+	AllowIPAddresses bool
+}
+
+func SPIFFEID(trustDomain, namespace, serviceAccount string) string {
+	return fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, namespace, serviceAccount)
+}
+
+func (p *SPIFFEProfile) isRequestForSigner(csrObj *capi.CertificateSigningRequest, req *x509.CertificateRequest) (bool, error) {
+	if len(req.URIs) != 1 {
+		return false, fmt.Errorf("SPIFFE certificates must contain exactly one URI SAN, found %d", len(req.URIs))
+	}
+
+	namespace, serviceAccount, ok := strings.Cut(strings.TrimPrefix(csrObj.Spec.Username, api.ServiceAccountUsernamePrefix), ":")
+	if !strings.HasPrefix(csrObj.Spec.Username, api.ServiceAccountUsernamePrefix) || !ok {
+		return false, fmt.Errorf("SPIFFE certificates can only be requested by ServiceAccounts, not by %q", csrObj.Spec.Username)
+	}
+	if uri, expected := req.URIs[0].String(), SPIFFEID(p.TrustDomain, namespace, serviceAccount); uri != expected {
+		return false, fmt.Errorf("URI SAN %q does not match the requesting ServiceAccount identity %q", uri, expected)
+	}
+
+	if len(req.DNSNames) > 0 && !p.AllowDNSNames {
+		return false, fmt.Errorf("DNS SANs are not allowed in SPIFFE certificates")
+	}
+	if len(req.IPAddresses) > 0 && !p.AllowIPAddresses {
+		return false, fmt.Errorf("IP SANs are not allowed in SPIFFE certificates")
+	}
+	if len(req.EmailAddresses) > 0 {
+		return false, fmt.Errorf("email SANs are not allowed in SPIFFE certificates")
+	}
+
+	if csrObj.Spec.ExpirationSeconds == nil {
+		return false, fmt.Errorf("SPIFFE certificates must specify expirationSeconds")
+	}
+	if ttl := csr.ExpirationSecondsToDuration(*csrObj.Spec.ExpirationSeconds); ttl > p.MaxTTL {
+		return false, fmt.Errorf("requested duration (%s) exceeds the maximum duration of SPIFFE certificates (%s)", ttl, p.MaxTTL)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,219 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/signing"
+)
+
+// notAfterTolerance absorbs the clock skew between the signer and the backend issuing the certificate
+const notAfterTolerance = time.Minute
+
+// VerificationError reports an issued certificate that does not match the request. Signing again would
+// produce the same result, so the CSR is marked as failed instead of being retried
+type VerificationError struct {
+	message string
+}
+
+func (e *VerificationError) Error() string {
+	return e.message
+}
+
+func verificationErrorf(format string, args ...interface{}) error {
+	return &VerificationError{message: fmt.Sprintf(format, args...)}
+}
+
+// verifyCertificate checks the issued certificate against the request before it is handed to the requester,
+// guarding against backend misconfigurations that widen what is issued
+func verifyCertificate(ca *caBundle, chain []*x509.Certificate, cr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration, now time.Time) error {
+	cert := chain[0]
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return verificationErrorf("unable to encode the public key of the issued certificate: %v", err)
+	}
+	requestKey, err := x509.MarshalPKIXPublicKey(cr.PublicKey)
+	if err != nil {
+		return verificationErrorf("unable to encode the public key of the request: %v", err)
+	}
+	if !bytes.Equal(certKey, requestKey) {
+		return verificationErrorf("the public key of the issued certificate does not match the request")
+	}
+
+	if latest := now.Add(ttl + notAfterTolerance); cert.NotAfter.After(latest) {
+		return verificationErrorf("the issued certificate expires at %s, after the requested duration %s", cert.NotAfter.Format(time.RFC3339), ttl)
+	}
+
+	if err := verifyUsages(cert, usage, extUsages); err != nil {
+		return err
+	}
+
+	if err := verifySANs(cert, cr); err != nil {
+		return err
+	}
+
+	return verifyChain(ca, chain)
+}
+
+// verifyUsages checks that the issued certificate has no usages other than the requested ones
+func verifyUsages(cert *x509.Certificate, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage) error {
+	if cert.KeyUsage|usage != usage {
+		return verificationErrorf("issued key usages (%d) exceed the requested ones (%d)", cert.KeyUsage, usage)
+	}
+
+	for _, issued := range cert.ExtKeyUsage {
+		if !slices.Contains(extUsages, issued) {
+			return verificationErrorf("issued extended key usages %v exceed the requested ones %v", cert.ExtKeyUsage, extUsages)
+		}
+	}
+
+	return nil
+}
+
+// verifySANs checks that the issued certificate contains the requested SANs. The only permitted addition is
+// the common name, which Vault adds to the DNS or email SANs unless the role excludes it
+func verifySANs(cert *x509.Certificate, cr *x509.CertificateRequest) error {
+	cn := cr.Subject.CommonName
+
+	if extra, missing := diff(cert.DNSNames, cr.DNSNames); len(missing) > 0 || !onlyCommonName(extra, cn) {
+		return verificationErrorf("issued DNS SANs %v do not match the requested ones %v", cert.DNSNames, cr.DNSNames)
+	}
+	if extra, missing := diff(cert.EmailAddresses, cr.EmailAddresses); len(missing) > 0 || !onlyCommonName(extra, cn) {
+		return verificationErrorf("issued email SANs %v do not match the requested ones %v", cert.EmailAddresses, cr.EmailAddresses)
+	}
+
+	var certIPs, requestIPs []string
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	for _, ip := range cr.IPAddresses {
+		requestIPs = append(requestIPs, ip.String())
+	}
+	if extra, missing := diff(certIPs, requestIPs); len(extra) > 0 || len(missing) > 0 {
+		return verificationErrorf("issued IP SANs %v do not match the requested ones %v", certIPs, requestIPs)
+	}
+
+	var certURIs, requestURIs []string
+	for _, uri := range cert.URIs {
+		certURIs = append(certURIs, uri.String())
+	}
+	for _, uri := range cr.URIs {
+		requestURIs = append(requestURIs, uri.String())
+	}
+	if extra, missing := diff(certURIs, requestURIs); len(extra) > 0 || len(missing) > 0 {
+		return verificationErrorf("issued URI SANs %v do not match the requested ones %v", certURIs, requestURIs)
+	}
+
+	return nil
+}
+
+// caBundle caches the CA certificates of a signer, so that they are not read from the backend for each
+// certificate. They are refreshed when an issued certificate does not chain to them, e.g., after a rotation
+type caBundle struct {
+	vsigner signing.Signer
+
+	lock  sync.Mutex
+	pem   []byte
+	roots *x509.CertPool
+}
+
+func newCABundle(vsigner signing.Signer) *caBundle {
+	return &caBundle{vsigner: vsigner}
+}
+
+func (b *caBundle) pool(refresh bool) (*x509.CertPool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.roots != nil && !refresh {
+		return b.roots, nil
+	}
+
+	caPEM, err := b.vsigner.CA()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the CA certificate: %v", err)
+	}
+	cas, err := api.ParseCertificates(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the CA certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+	b.pem, b.roots = caPEM, roots
+	return roots, nil
+}
+
+// certificates returns the PEM-encoded CA certificates
+func (b *caBundle) certificates() ([]byte, error) {
+	if _, err := b.pool(false); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.pem, nil
+}
+
+// verifyChain checks that the issued certificate chains to the CA of the signer. Failures to retrieve the CA
+// are not verification errors, so the CSR is retried with the certificate kept in the issuance cache
+func verifyChain(ca *caBundle, chain []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verify := func(roots *x509.CertPool) error {
+		_, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   chain[0].NotBefore,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return err
+	}
+
+	roots, err := ca.pool(false)
+	if err != nil {
+		return err
+	}
+	if err := verify(roots); err == nil {
+		return nil
+	}
+	if roots, err = ca.pool(true); err != nil {
+		return err
+	}
+	if err := verify(roots); err != nil {
+		return verificationErrorf("the issued certificate does not chain to the signer CA: %v", err)
+	}
+	return nil
+}
+
+// diff returns the values only found in issued and in requested, respectively
+func diff(issued, requested []string) (extra, missing []string) {
+	for _, v := range issued {
+		if !slices.Contains(requested, v) {
+			extra = append(extra, v)
+		}
+	}
+	for _, v := range requested {
+		if !slices.Contains(issued, v) {
+			missing = append(missing, v)
+		}
+	}
+	return extra, missing
+}
+
+func onlyCommonName(extra []string, cn string) bool {
+	for _, v := range extra {
+		if cn == "" || !strings.EqualFold(v, cn) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package secrets
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	controller "github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates/signer"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// rejectionRetryPeriod is the delay after which rejected certificates are requested again, as the signing policies,
+// the namespace annotations, or the permissions of the requester may have changed in the meantime
+const rejectionRetryPeriod = 30 * time.Minute
+
+// Signer issues the certificates of the managed Secrets, returning the PEM-encoded certificate and CA
+type Signer interface {
+	Sign(ctx context.Context, csr *capi.CertificateSigningRequest) ([]byte, []byte, error)
+}
+
+type SecretController struct {
+	kubeClient   clientset.Interface
+	secretLister corelisters.SecretLister
+	nsLister     corelisters.NamespaceLister
+	cacheSynced  []cache.InformerSynced
+	signer       Signer
+	queue        workqueue.RateLimitingInterface
+	broadcaster  record.EventBroadcaster
+	recorder     record.EventRecorder
+
+	lock            sync.RWMutex
+	signerName      string
+	trustDomain     string
+	renewalFraction float64
+}
+
+func NewSecretController(
+	ctx context.Context,
+	kubeClient clientset.Interface,
+	secretInformer coreinformers.SecretInformer,
+	nsInformer coreinformers.NamespaceInformer,
+	signer Signer,
+	signerName string,
+	trustDomain string,
+	renewalFraction float64,
+	rateLimiter workqueue.RateLimiter,
+) *SecretController {
+	logger := klog.FromContext(ctx)
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	sc := &SecretController{
+		kubeClient:      kubeClient,
+		signer:          signer,
+		signerName:      signerName,
+		trustDomain:     trustDomain,
+		renewalFraction: renewalFraction,
+		queue:           workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{Name: "secret"}),
+		broadcaster:     broadcaster,
+		recorder:        broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "vault-signer"}),
+	}
+
+	_, err := secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			logger.V(4).Info("Adding managed certificate secret", "secret", klog.KObj(obj.(*v1.Secret)))
+			sc.enqueue(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			logger.V(4).Info("Updating managed certificate secret", "secret", klog.KObj(new.(*v1.Secret)))
+			sc.enqueue(new)
+		},
+	})
+	if err != nil {
+		klog.Exitf("Error adding secret controller event handler: %v", err)
+	}
+
+	sc.secretLister = secretInformer.Lister()
+	sc.nsLister = nsInformer.Lister()
+	sc.cacheSynced = []cache.InformerSynced{
+		secretInformer.Informer().HasSynced,
+		nsInformer.Informer().HasSynced,
+	}
+
+	return sc
+}
+
+// Update replaces the signer settings used for the next issuances, e.g., after a configuration reload
+func (sc *SecretController) Update(signerName, trustDomain string, renewalFraction float64) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	sc.signerName, sc.trustDomain, sc.renewalFraction = signerName, trustDomain, renewalFraction
+}
+
+func (sc *SecretController) settings() (string, string, float64) {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	return sc.signerName, sc.trustDomain, sc.renewalFraction
+}
+
+func (sc *SecretController) Run(ctx context.Context, workers int, drainTimeout time.Duration) {
+	defer utilruntime.HandleCrash()
+	defer sc.queue.ShutDown()
+
+	sc.broadcaster.StartStructuredLogging(3)
+	sc.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: sc.kubeClient.CoreV1().Events("")})
+	defer sc.broadcaster.Shutdown()
+
+	logger := klog.FromContext(ctx)
+	logger.Info("Starting managed certificate secret controller")
+	defer logger.Info("Shutting down managed certificate secret controller")
+
+	if !cache.WaitForNamedCacheSync("secret", ctx.Done(), sc.cacheSynced...) {
+		return
+	}
+
+	controller.RunWorkers(ctx, sc.queue, workers, drainTimeout, sc.worker)
+}
+
+func (sc *SecretController) worker(stop, ctx context.Context) {
+	for sc.processNextWorkItem(stop, ctx) {
+	}
+}
+
+func (sc *SecretController) processNextWorkItem(stop, ctx context.Context) bool {
+	key, quit := sc.queue.Get()
+	if quit {
+		return false
+	}
+	defer sc.queue.Done(key)
+	if controller.Stopped(stop) {
+		return false
+	}
+
+	if err := sc.syncFunc(ctx, key.(string)); err != nil {
+		sc.queue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("sync %v failed with: %v", key, err))
+		return true
+	}
+
+	sc.queue.Forget(key)
+	return true
+}
+
+func (sc *SecretController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", obj, err))
+		return
+	}
+	sc.queue.Add(key)
+}
+
+func (sc *SecretController) syncFunc(ctx context.Context, key string) error {
+	logger := klog.FromContext(ctx)
+	startTime := time.Now()
+	defer func() {
+		logger.V(4).Info("Finished syncing managed certificate secret", "secret", key, "elapsedTime", time.Since(startTime))
+	}()
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	secret, err := sc.secretLister.Secrets(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		logger.V(3).Info("managed certificate secret has been deleted", "secret", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	signerName, trustDomain, renewalFraction := sc.settings()
+	spec, err := parseCertificateSpec(secret, renewalFraction)
+	if err != nil {
+		// wait for the annotations to be fixed
+		logger.Error(err, "invalid managed certificate specification", "secret", key)
+		sc.recorder.Eventf(secret, v1.EventTypeWarning, "InvalidSpecification", "Invalid certificate specification: %v", err)
+		return nil
+	}
+
+	hash := spec.hash()
+	if cert := issuedCertificate(secret); cert != nil && secret.Annotations[v1alpha1.IssuedSpecHashAnnotation] == hash {
+		if renewal := time.Until(spec.renewalTime(cert)); renewal > 0 {
+			logger.V(4).Info("Scheduling certificate renewal", "secret", key, "renewal", renewal)
+			sc.queue.AddAfter(key, renewal)
+			return nil
+		}
+	}
+
+	return sc.issue(ctx, secret, spec, hash, signerName, trustDomain)
+}
+
+func (sc *SecretController) issue(ctx context.Context, secret *v1.Secret, spec *certificateSpec, hash, signerName, trustDomain string) error {
+	logger := klog.FromContext(ctx)
+
+	ns, err := sc.nsLister.Get(secret.Namespace)
+	if err != nil {
+		return err
+	}
+
+	cr, keyPEM, err := spec.certificateRequest()
+	if err != nil {
+		return err
+	}
+	if err := policy.NewNamespacePolicy(ns, trustDomain).ValidateRequest(cr, spec.usages); err != nil {
+		sc.reject(ctx, secret, "NamespacePolicyViolation", err.Error())
+		return nil
+	}
+
+	certPEM, caPEM, err := sc.signer.Sign(ctx, spec.signingRequest(secret, signerName, cr))
+	var rerr *signer.RejectionError
+	if goerrors.As(err, &rerr) {
+		sc.reject(ctx, secret, rerr.Reason, rerr.Message)
+		return nil
+	} else if err != nil {
+		sc.recorder.Eventf(secret, v1.EventTypeWarning, "SigningFailed", "Error signing certificate, retrying: %v", err)
+		return fmt.Errorf("error signing certificate for secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+
+	secret = secret.DeepCopy()
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[v1alpha1.IssuedSpecHashAnnotation] = hash
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[v1.TLSPrivateKeyKey] = keyPEM
+	secret.Data[v1.TLSCertKey] = certPEM
+	secret.Data[caCertKey] = caPEM
+
+	_, err = sc.kubeClient.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("error updating managed certificate secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	logger.V(2).Info("Issued managed certificate", "secret", klog.KObj(secret))
+	sc.recorder.Event(secret, v1.EventTypeNormal, "Issued", "Certificate issued")
+	return nil
+}
+
+// reject reports the rejection of the certificate of the Secret through an Event, and requests it again later
+func (sc *SecretController) reject(ctx context.Context, secret *v1.Secret, reason, message string) {
+	klog.FromContext(ctx).Info("Managed certificate rejected", "secret", klog.KObj(secret), "reason", reason, "message", message)
+	sc.recorder.Eventf(secret, v1.EventTypeWarning, reason, "Certificate rejected, retrying in %s: %s", rejectionRetryPeriod, message)
+	key, err := cache.MetaNamespaceKeyFunc(secret)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", secret, err))
+		return
+	}
+	sc.queue.AddAfter(key, rejectionRetryPeriod)
+}
@@ -0,0 +1,228 @@
+package secrets
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certcsr "k8s.io/client-go/util/certificate/csr"
+)
+
+const caCertKey = "ca.crt"
+
+var defaultUsages = []capi.KeyUsage{
+	capi.UsageDigitalSignature,
+	capi.UsageKeyEncipherment,
+	capi.UsageServerAuth,
+	capi.UsageClientAuth,
+}
+
+type certificateSpec struct {
+	commonName    string
+	organizations []string
+	dnsNames      []string
+	ipAddresses   []net.IP
+	uris          []*url.URL
+	usages        []capi.KeyUsage
+	profile       string
+	// duration is the requested duration, capped by the signer, or zero for the signer default
+	duration        time.Duration
+	renewalFraction float64
+	keyAlgorithm    string
+}
+
+func parseCertificateSpec(secret *v1.Secret, defaultRenewalFraction float64) (*certificateSpec, error) {
+	annotations := secret.Annotations
+	spec := &certificateSpec{
+		commonName:      annotations[v1alpha1.CommonNameAnnotation],
+		organizations:   splitList(annotations[v1alpha1.OrganizationsAnnotation]),
+		dnsNames:        splitList(annotations[v1alpha1.DNSNamesAnnotation]),
+		usages:          defaultUsages,
+		profile:         annotations[v1alpha1.ProfileAnnotation],
+		renewalFraction: defaultRenewalFraction,
+		keyAlgorithm:    "ECDSA",
+	}
+
+	if secret.Type != v1.SecretTypeTLS {
+		return nil, fmt.Errorf("managed certificate secrets must be of type %s, not %s", v1.SecretTypeTLS, secret.Type)
+	}
+
+	if spec.commonName == "" && len(spec.dnsNames) == 0 {
+		return nil, fmt.Errorf("at least one of the %s and %s annotations is required", v1alpha1.CommonNameAnnotation, v1alpha1.DNSNamesAnnotation)
+	}
+	for _, s := range splitList(annotations[v1alpha1.IPAddressesAnnotation]) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		spec.ipAddresses = append(spec.ipAddresses, ip)
+	}
+	for _, s := range splitList(annotations[v1alpha1.URIsAnnotation]) {
+		uri, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URI %q: %v", s, err)
+		}
+		spec.uris = append(spec.uris, uri)
+	}
+	if usages := splitList(annotations[v1alpha1.UsagesAnnotation]); len(usages) > 0 {
+		spec.usages = nil
+		for _, usage := range usages {
+			spec.usages = append(spec.usages, capi.KeyUsage(usage))
+		}
+	}
+	if s, ok := annotations[v1alpha1.DurationAnnotation]; ok {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %v", s, err)
+		} else if duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q: must be positive", s)
+		}
+		spec.duration = duration
+	}
+	if s, ok := annotations[v1alpha1.RenewalFractionAnnotation]; ok {
+		fraction, err := strconv.ParseFloat(s, 64)
+		if err != nil || fraction <= 0 || fraction >= 1 {
+			return nil, fmt.Errorf("invalid renewal fraction %q: must be a number between 0 and 1", s)
+		}
+		spec.renewalFraction = fraction
+	}
+	if s, ok := annotations[v1alpha1.PrivateKeyAlgorithmAnnotation]; ok {
+		switch alg := strings.ToUpper(s); alg {
+		case "ECDSA", "RSA":
+			spec.keyAlgorithm = alg
+		default:
+			return nil, fmt.Errorf("unsupported private key algorithm %q", s)
+		}
+	}
+
+	return spec, nil
+}
+
+// hash identifies the issued certificate, so that changes to the specification trigger a new issuance
+func (s *certificateSpec) hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%q|%q|%q|%q|%q|%s|%s|%s", s.commonName, s.organizations, s.dnsNames, s.ipAddresses, s.uris, s.usages, s.duration, s.keyAlgorithm, s.profile)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *certificateSpec) renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * s.renewalFraction))
+}
+
+func (s *certificateSpec) certificateRequest() (*x509.CertificateRequest, []byte, error) {
+	var key crypto.Signer
+	var err error
+	switch s.keyAlgorithm {
+	case "RSA":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate private key: %v", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   s.commonName,
+			Organization: s.organizations,
+		},
+		DNSNames:    s.dnsNames,
+		IPAddresses: s.ipAddresses,
+		URIs:        s.uris,
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create certificate request: %v", err)
+	}
+	cr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse certificate request: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to encode private key: %v", err)
+	}
+
+	return cr, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// signingRequest wraps the certificate request of the Secret in an approved CSR on behalf of the
+// system:vault:<namespace>:<name> identity, which is passed to the signer without being created
+func (s *certificateSpec) signingRequest(secret *v1.Secret, signerName string, cr *x509.CertificateRequest) *capi.CertificateSigningRequest {
+	csr := &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   secret.Namespace + "/" + secret.Name,
+			UID:    secret.UID,
+			Labels: map[string]string{v1alpha1.RequestNamespaceLabel: secret.Namespace},
+		},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: cr.Raw}),
+			SignerName: signerName,
+			Usages:     s.usages,
+			Username:   policy.RequesterUsername(secret.Namespace, secret.Name),
+			Groups:     policy.RequesterGroups(secret.Namespace),
+		},
+		Status: capi.CertificateSigningRequestStatus{
+			Conditions: []capi.CertificateSigningRequestCondition{{
+				Type:    capi.CertificateApproved,
+				Status:  v1.ConditionTrue,
+				Reason:  "ManagedCertificate",
+				Message: "Certificate requested by a managed Secret",
+			}},
+		},
+	}
+	if s.profile != "" {
+		csr.Annotations = map[string]string{v1alpha1.ProfileAnnotation: s.profile}
+	}
+	if s.duration > 0 {
+		// longer durations are capped by the signer anyway
+		csr.Spec.ExpirationSeconds = certcsr.DurationToExpirationSeconds(min(s.duration, math.MaxInt32*time.Second))
+	}
+	return csr
+}
+
+// issuedCertificate returns the certificate stored in the Secret, if it is valid and matches the private key
+func issuedCertificate(secret *v1.Secret) *x509.Certificate {
+	if _, err := tls.X509KeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]); err != nil {
+		return nil
+	}
+	certs, err := api.ParseCertificates(secret.Data[v1.TLSCertKey])
+	if err != nil {
+		return nil
+	}
+	return certs[0]
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
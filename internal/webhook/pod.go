@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/internal/apis/vault/v1alpha1"
+	"github.com/alpha-unito/k8s-vault-signer/internal/controller/certificates/signer"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	certsVolumeName = "vault-signer-certs"
+	certsMountPath  = "/var/run/secrets/vault-signer"
+	initContainer   = "vault-signer-init"
+)
+
+// PodInjector adds an init container to annotated Pods, which requests a certificate
+// for the Pod ServiceAccount and shares it with the other containers
+type PodInjector struct {
+	lock        sync.RWMutex
+	image       string
+	signerName  string
+	trustDomain string
+	spiffeTTL   time.Duration
+}
+
+// NewPodInjector creates a PodInjector. If a SPIFFE trust domain is specified, injected
+// Pods request a SPIFFE X.509-SVID with the given default duration
+func NewPodInjector(image, signerName, trustDomain string, spiffeTTL time.Duration) *PodInjector {
+	return &PodInjector{image: image, signerName: signerName, trustDomain: trustDomain, spiffeTTL: spiffeTTL}
+}
+
+// Update replaces the settings of the injector, e.g., after a configuration reload
+func (i *PodInjector) Update(image, signerName, trustDomain string, spiffeTTL time.Duration) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.image, i.signerName, i.trustDomain, i.spiffeTTL = image, signerName, trustDomain, spiffeTTL
+}
+
+func (i *PodInjector) Mutate(ctx context.Context, req *admissionv1.AdmissionRequest) ([]patchOperation, error) {
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return nil, nil
+	}
+
+	pod := &v1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		return nil, fmt.Errorf("unable to decode pod: %v", err)
+	}
+	if pod.Annotations[v1alpha1.InjectAnnotation] != "true" {
+		return nil, nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == certsVolumeName {
+			return nil, nil
+		}
+	}
+
+	i.lock.RLock()
+	image, signerName, trustDomain, spiffeTTL := i.image, i.signerName, i.trustDomain, i.spiffeTTL
+	i.lock.RUnlock()
+
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	args := []string{
+		"request",
+		fmt.Sprintf("--common-name=%s%s:%s", api.ServiceAccountUsernamePrefix, req.Namespace, serviceAccount),
+		fmt.Sprintf("--dir=%s", certsMountPath),
+		fmt.Sprintf("--signer-name=%s", signerName),
+	}
+	if trustDomain != "" {
+		args = append(args, fmt.Sprintf("--uris=%s", signer.SPIFFEID(trustDomain, req.Namespace, serviceAccount)))
+		if pod.Annotations[v1alpha1.DurationAnnotation] == "" {
+			args = append(args, fmt.Sprintf("--duration=%s", spiffeTTL))
+		}
+	}
+	if dnsNames := pod.Annotations[v1alpha1.DNSNamesAnnotation]; dnsNames != "" {
+		args = append(args, fmt.Sprintf("--dns-names=%s", dnsNames))
+	}
+	if usages := pod.Annotations[v1alpha1.UsagesAnnotation]; usages != "" {
+		args = append(args, fmt.Sprintf("--usages=%s", usages))
+	}
+	if duration := pod.Annotations[v1alpha1.DurationAnnotation]; duration != "" {
+		args = append(args, fmt.Sprintf("--duration=%s", duration))
+	}
+
+	mount := v1.VolumeMount{Name: certsVolumeName, MountPath: certsMountPath}
+	container := v1.Container{
+		Name:         initContainer,
+		Image:        image,
+		Args:         args,
+		VolumeMounts: []v1.VolumeMount{mount},
+	}
+	volume := v1.Volume{
+		Name: certsVolumeName,
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory},
+		},
+	}
+
+	var patch []patchOperation
+	patch = append(patch, appendOperation("/spec/volumes", len(pod.Spec.Volumes), volume))
+	// the init container must run before the existing ones
+	if len(pod.Spec.InitContainers) == 0 {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/initContainers", Value: []v1.Container{container}})
+	} else {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/initContainers/0", Value: container})
+	}
+	mount.ReadOnly = true
+	for j, c := range pod.Spec.InitContainers {
+		patch = append(patch, appendOperation(fmt.Sprintf("/spec/initContainers/%d/volumeMounts", j+1), len(c.VolumeMounts), mount))
+	}
+	for j, c := range pod.Spec.Containers {
+		patch = append(patch, appendOperation(fmt.Sprintf("/spec/containers/%d/volumeMounts", j), len(c.VolumeMounts), mount))
+	}
+
+	return patch, nil
+}
+
+func appendOperation(path string, length int, value interface{}) patchOperation {
+	if length == 0 {
+		return patchOperation{Op: "add", Path: path, Value: []interface{}{value}}
+	}
+	return patchOperation{Op: "add", Path: strings.TrimSuffix(path, "/") + "/-", Value: value}
+}
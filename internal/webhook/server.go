@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+type Server struct {
+	server   *http.Server
+	certFile string
+	keyFile  string
+}
+
+func NewServer(address, certFile, keyFile string, injector *PodInjector) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/mutate-pods", admissionHandler(injector.Mutate))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return &Server{
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.server.Shutdown(shutdownCtx)
+	}()
+
+	klog.FromContext(ctx).Info("Starting admission webhook server", "address", s.server.Addr)
+	if err := s.server.ListenAndServeTLS(s.certFile, s.keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admission webhook server failed: %v", err)
+	}
+	return nil
+}
+
+type mutateFunc func(ctx context.Context, req *admissionv1.AdmissionRequest) ([]patchOperation, error)
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func admissionHandler(mutate mutateFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 3*1024*1024))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to read request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "invalid admission review", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+		patch, err := mutate(r.Context(), review.Request)
+		if err != nil {
+			klog.ErrorS(err, "unable to mutate object", "kind", review.Request.Kind, "namespace", review.Request.Namespace, "name", review.Request.Name)
+			response.Allowed = false
+			response.Result = &metav1.Status{Message: err.Error(), Code: http.StatusBadRequest}
+		} else if len(patch) > 0 {
+			patchBytes, err := json.Marshal(patch)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to encode patch: %v", err), http.StatusInternalServerError)
+				return
+			}
+			patchType := admissionv1.PatchTypeJSONPatch
+			response.Patch = patchBytes
+			response.PatchType = &patchType
+		}
+
+		review.Response = response
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			klog.ErrorS(err, "unable to write admission response")
+		}
+	})
+}
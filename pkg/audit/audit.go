@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type Outcome string
+
+const (
+	Issued   Outcome = "Issued"
+	Rejected Outcome = "Rejected"
+)
+
+// Record describes a certificate issued or rejected by a signer. Records never contain key material
+type Record struct {
+	Time      time.Time `json:"time"`
+	CSR       string    `json:"csr"`
+	UID       types.UID `json:"uid"`
+	Requester Requester `json:"requester"`
+	Approver  Approver  `json:"approver"`
+	Signer    string    `json:"signer"`
+	Pki       string    `json:"pki,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+
+	Outcome Outcome `json:"outcome"`
+	Reason  string  `json:"reason,omitempty"`
+	Message string  `json:"message,omitempty"`
+
+	Serial         string     `json:"serial,omitempty"`
+	Subject        string     `json:"subject"`
+	DNSNames       []string   `json:"dnsNames,omitempty"`
+	IPAddresses    []string   `json:"ipAddresses,omitempty"`
+	URIs           []string   `json:"uris,omitempty"`
+	EmailAddresses []string   `json:"emailAddresses,omitempty"`
+	Usages         []string   `json:"usages,omitempty"`
+	NotBefore      *time.Time `json:"notBefore,omitempty"`
+	NotAfter       *time.Time `json:"notAfter,omitempty"`
+}
+
+type Requester struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// Approver is taken from the Approved condition of the CSR, which records how the request was approved
+// but not by whom
+type Approver struct {
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Sink persists audit records. Write must return only after the record is stored
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+	Close() error
+}
+
+// NewSink returns a JSON lines sink writing to the file at path, or to stdout if path is -. If path is
+// empty, auditing is disabled and the returned sink is nil
+func NewSink(path string) (Sink, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return NewJSONLinesSink(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %v", path, err)
+	}
+	return NewJSONLinesSink(f), nil
+}
+
+type syncer interface {
+	Sync() error
+}
+
+// JSONLinesSink writes each record as a line of JSON, syncing files after each write
+type JSONLinesSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+func (s *JSONLinesSink) Write(_ context.Context, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %v", err)
+	}
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.w.Write(data); err != nil {
+		return fmt.Errorf("unable to write audit record: %v", err)
+	}
+	// stdout is not synced, as it usually is a pipe or a terminal
+	if f, ok := s.w.(syncer); ok && s.w != os.Stdout {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("unable to sync audit log: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *JSONLinesSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request body, prefixed by sha256=
+const SignatureHeader = "X-Vault-Signer-Signature"
+
+// Filter selects the records notified by a webhook. Empty lists match any value
+type Filter struct {
+	Signers  []string
+	Outcomes []Outcome
+	Profiles []string
+	Reasons  []string
+	// MinRejections notifies rejections only when the same requester had at least MinRejections rejections
+	// by the same signer within RejectionWindow
+	MinRejections   int
+	RejectionWindow time.Duration
+}
+
+type WebhookOptions struct {
+	URL        string
+	Secret     []byte
+	Filter     Filter
+	Timeout    time.Duration
+	MaxRetries int
+	QueueSize  int
+	Client     *http.Client
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff of failed requests, 1s and 5m if zero
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+// WebhookSink posts the records matching its filter to an HTTP endpoint. Records are queued and sent by a
+// background worker, so that Write never blocks the callers. Failed requests are retried with backoff
+type WebhookSink struct {
+	opts  WebhookOptions
+	queue workqueue.TypedRateLimitingInterface[*Record]
+
+	lock       sync.Mutex
+	rejections map[string][]time.Time
+}
+
+// NewWebhookSink creates the sink and starts its worker, which stops when the context is done or the
+// sink is closed
+func NewWebhookSink(ctx context.Context, opts WebhookOptions) *WebhookSink {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Timeout}
+	}
+	if opts.RetryBaseDelay == 0 {
+		opts.RetryBaseDelay = time.Second
+	}
+	if opts.RetryMaxDelay == 0 {
+		opts.RetryMaxDelay = 5 * time.Minute
+	}
+	s := &WebhookSink{
+		opts: opts,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[*Record](opts.RetryBaseDelay, opts.RetryMaxDelay),
+			workqueue.TypedRateLimitingQueueConfig[*Record]{Name: "audit-webhook"},
+		),
+		rejections: make(map[string][]time.Time),
+	}
+	go wait.UntilWithContext(ctx, s.worker, time.Second)
+	go func() {
+		<-ctx.Done()
+		s.queue.ShutDown()
+	}()
+	return s
+}
+
+func (s *WebhookSink) Write(_ context.Context, r *Record) error {
+	if !s.matches(r) {
+		return nil
+	}
+	if s.opts.QueueSize > 0 && s.queue.Len() >= s.opts.QueueSize {
+		klog.Warningf("dropping notification of csr %q to %s: queue is full", r.CSR, s.opts.URL)
+		return nil
+	}
+	s.queue.Add(r)
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	s.queue.ShutDown()
+	return nil
+}
+
+func (s *WebhookSink) matches(r *Record) bool {
+	f := s.opts.Filter
+	if len(f.Signers) > 0 && !slices.Contains(f.Signers, r.Signer) {
+		return false
+	}
+	if len(f.Outcomes) > 0 && !slices.Contains(f.Outcomes, r.Outcome) {
+		return false
+	}
+	if len(f.Profiles) > 0 && !slices.Contains(f.Profiles, r.Profile) {
+		return false
+	}
+	if len(f.Reasons) > 0 && !slices.Contains(f.Reasons, r.Reason) {
+		return false
+	}
+	if r.Outcome == Rejected && f.MinRejections > 1 {
+		return s.countRejection(r) >= f.MinRejections
+	}
+	return true
+}
+
+// countRejection records the rejection and returns the number of rejections of the requester within the window
+func (s *WebhookSink) countRejection(r *Record) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := r.Signer + "/" + r.Requester.Username
+	since := r.Time.Add(-s.opts.Filter.RejectionWindow)
+	times := slices.DeleteFunc(s.rejections[key], func(t time.Time) bool {
+		return s.opts.Filter.RejectionWindow > 0 && t.Before(since)
+	})
+	times = append(times, r.Time)
+	s.rejections[key] = times
+	return len(times)
+}
+
+func (s *WebhookSink) worker(ctx context.Context) {
+	for s.processNextItem(ctx) {
+	}
+}
+
+func (s *WebhookSink) processNextItem(ctx context.Context) bool {
+	r, quit := s.queue.Get()
+	if quit {
+		return false
+	}
+	defer s.queue.Done(r)
+
+	if err := s.post(ctx, r); err != nil {
+		if s.queue.NumRequeues(r) < s.opts.MaxRetries {
+			klog.V(2).Infof("retrying notification of csr %q to %s: %v", r.CSR, s.opts.URL, err)
+			s.queue.AddRateLimited(r)
+			return true
+		}
+		klog.Errorf("dropping notification of csr %q to %s after %d retries: %v", r.CSR, s.opts.URL, s.opts.MaxRetries, err)
+	}
+	s.queue.Forget(r)
+	return true
+}
+
+func (s *WebhookSink) post(ctx context.Context, r *Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.opts.Secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(s.opts.Secret, body))
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of the body, as sent in the SignatureHeader
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MultiSink writes each record to all the sinks, returning the first error
+type MultiSink []Sink
+
+func (m MultiSink) Write(ctx context.Context, r *Record) error {
+	for _, s := range m {
+		if err := s.Write(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRecord(csr string, outcome Outcome) *Record {
+	return &Record{
+		Time:      time.Now(),
+		CSR:       csr,
+		Requester: Requester{Username: "alice"},
+		Signer:    "unito.it/vault-signer",
+		Outcome:   outcome,
+		Subject:   "CN=app",
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("s3cr3t")
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := NewWebhookSink(ctx, WebhookOptions{URL: server.URL, Secret: secret, Timeout: time.Second})
+	defer func() { _ = sink.Close() }()
+
+	if err := sink.Write(ctx, newRecord("app", Issued)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json, got %s", ct)
+		}
+		if got, want := r.Header.Get(SignatureHeader), "sha256="+Sign(secret, body); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+		if Sign(secret, body) == Sign([]byte("other"), body) {
+			t.Error("signature does not depend on the secret")
+		}
+		var record Record
+		if err := json.Unmarshal(body, &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.CSR != "app" || record.Outcome != Issued {
+			t.Errorf("unexpected record %+v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int32
+		maxRetries int
+		calls      int32
+	}{
+		{name: "succeeds after retries", failures: 2, maxRetries: 5, calls: 3},
+		{name: "dropped after max retries", failures: 100, maxRetries: 3, calls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sink := NewWebhookSink(ctx, WebhookOptions{
+				URL:            server.URL,
+				Timeout:        time.Second,
+				MaxRetries:     tt.maxRetries,
+				RetryBaseDelay: 10 * time.Millisecond,
+				RetryMaxDelay:  50 * time.Millisecond,
+			})
+			defer func() { _ = sink.Close() }()
+
+			start := time.Now()
+			if err := sink.Write(ctx, newRecord("app", Issued)); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt32(&calls) >= tt.calls })
+			// the backoff delays the retries: 10ms, 20ms, 40ms, ...
+			if elapsed := time.Since(start); elapsed < 10*time.Millisecond*time.Duration(tt.calls-1) {
+				t.Errorf("retries were not delayed: %d calls in %s", tt.calls, elapsed)
+			}
+			time.Sleep(200 * time.Millisecond)
+			if got := atomic.LoadInt32(&calls); got != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, got)
+			}
+		})
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	var calls int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := NewWebhookSink(ctx, WebhookOptions{URL: server.URL, Timeout: 5 * time.Second, QueueSize: 1})
+	defer func() { _ = sink.Close() }()
+
+	// the first record is being sent, the second one fills the queue, and the third one is dropped
+	if err := sink.Write(ctx, newRecord("first", Issued)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for _, name := range []string{"second", "third"} {
+		if err := sink.Write(ctx, newRecord(name, Issued)); err != nil {
+			t.Fatalf("writes must not fail when the queue is full: %v", err)
+		}
+	}
+	close(release)
+
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt32(&calls) >= 2 })
+	time.Sleep(200 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("expected 2 calls, got %d", got)
+	}
+}
+
+func TestWebhookFilter(t *testing.T) {
+	sink := &WebhookSink{
+		opts:       WebhookOptions{Filter: Filter{Outcomes: []Outcome{Rejected}, MinRejections: 2, RejectionWindow: time.Minute}},
+		rejections: make(map[string][]time.Time),
+	}
+	if sink.matches(newRecord("issued", Issued)) {
+		t.Error("issued record must not match a filter on rejections")
+	}
+	if sink.matches(newRecord("first", Rejected)) {
+		t.Error("first rejection must not match a filter on repeated rejections")
+	}
+	if !sink.matches(newRecord("second", Rejected)) {
+		t.Error("second rejection within the window must match")
+	}
+}
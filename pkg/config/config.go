@@ -1,76 +1,724 @@
 package config
 
 import (
+	"crypto/x509/pkix"
 	"fmt"
 	"os"
+	"strconv"
+	"text/template"
 	"time"
 
+	api "github.com/alpha-unito/k8s-vault-signer/internal/apis/certificates"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	vault "github.com/alpha-unito/k8s-vault-signer/pkg/vault/client"
 	"github.com/spf13/pflag"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 )
 
+const (
+	APIVersion = "vault-signer.unito.it/v1alpha1"
+	Kind       = "Configuration"
+)
+
+// MinCertificateDuration is the shortest duration of the issued certificates, as for the kube-controller-manager
+const MinCertificateDuration = 10 * time.Minute
+
 type Config struct {
-	Kubeconfig      string
-	SigningDuration metav1.Duration
-	VaultAddress    string
-	VaultAuthConfig string
-	VaultPki        string
-	VaultRole       string
+	metav1.TypeMeta `json:",inline"`
+
+	Kubeconfig          string                    `json:"kubeconfig,omitempty"`
+	Vault               VaultConfig               `json:"vault"`
+	Signers             []SignerConfig            `json:"signers"`
+	SigningPolicy       string                    `json:"signingPolicy,omitempty"`
+	Controller          ControllerConfig          `json:"controller"`
+	CertificateRequests CertificateRequestsConfig `json:"certificateRequests"`
+	ManagedSecrets      ManagedSecretsConfig      `json:"managedSecrets"`
+	Webhook             WebhookConfig             `json:"webhook"`
+	Audit               AuditConfig               `json:"audit"`
+	Tracing             TracingConfig             `json:"tracing"`
+	Metrics             MetricsConfig             `json:"metrics"`
+	Inventory           InventoryConfig           `json:"inventory"`
+
+	file  string
+	flags flagValues
+}
+
+type VaultConfig struct {
+	Address string `json:"address"`
+	// Path of a gcfg authentication file, alternative to the inline Auth configuration
+	AuthConfig string            `json:"authConfig,omitempty"`
+	Auth       *vault.AuthConfig `json:"auth,omitempty"`
+	// Revoke the Vault token on shutdown, instead of letting it expire
+	RevokeTokenOnExit bool `json:"revokeTokenOnExit,omitempty"`
+}
+
+type SignerConfig struct {
+	Name            string          `json:"name"`
+	Pki             string          `json:"pki,omitempty"`
+	Role            string          `json:"role,omitempty"`
+	SigningDuration metav1.Duration `json:"signingDuration"`
+	SPIFFE          *SPIFFEConfig   `json:"spiffe,omitempty"`
+	// Issue certificates through the sign-verbatim endpoint, preserving the requested subject and SANs.
+	// Verbatim signers require a signing policy
+	Verbatim bool `json:"verbatim,omitempty"`
+	// Local CA used instead of Vault, e.g., in development clusters
+	Local *LocalCAConfig `json:"local,omitempty"`
+	// Authorize the requesters of CSRs through SubjectAccessReviews
+	RequesterAuthorization *RequesterAuthorizationConfig `json:"requesterAuthorization,omitempty"`
+	// Profiles selected by CSRs through the vault.unito.it/profile annotation
+	Profiles []ProfileConfig `json:"profiles,omitempty"`
+	// Client-side limits of the sign calls, e.g., to protect a Vault cluster shared with other tenants
+	Limits *SignerLimitsConfig `json:"limits,omitempty"`
+}
+
+// SignerLimitsConfig caps the rate and the concurrency of the sign calls. Zero values disable the limit
+type SignerLimitsConfig struct {
+	QPS            float64               `json:"qps,omitempty"`
+	Burst          int                   `json:"burst,omitempty"`
+	MaxInFlight    int                   `json:"maxInFlight,omitempty"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+}
+
+// CircuitBreakerConfig stops the sign calls for openDuration after failureThreshold consecutive server
+// errors or timeouts
+type CircuitBreakerConfig struct {
+	FailureThreshold int             `json:"failureThreshold"`
+	OpenDuration     metav1.Duration `json:"openDuration"`
+}
+
+// ProfileConfig restricts the certificates requested through a profile. The commonName and SAN fields are
+// Go templates rendered with the .Username, .Groups, .Namespace, and .ServiceAccount of the requester
+type ProfileConfig struct {
+	Name                       string          `json:"name"`
+	Usages                     []string        `json:"usages,omitempty"`
+	MinDuration                metav1.Duration `json:"minDuration,omitempty"`
+	MaxDuration                metav1.Duration `json:"maxDuration,omitempty"`
+	RequiredSubjectAttributes  []string        `json:"requiredSubjectAttributes,omitempty"`
+	ForbiddenSubjectAttributes []string        `json:"forbiddenSubjectAttributes,omitempty"`
+	CommonName                 string          `json:"commonName,omitempty"`
+	DNSNames                   []string        `json:"dnsNames,omitempty"`
+	URIs                       []string        `json:"uris,omitempty"`
+	IPAddresses                []string        `json:"ipAddresses,omitempty"`
+	EmailAddresses             []string        `json:"emailAddresses,omitempty"`
+}
+
+// SubjectAttributes report whether a subject has each of the attributes that profiles can require or forbid
+var SubjectAttributes = map[string]func(pkix.Name) bool{
+	"commonName":         func(n pkix.Name) bool { return n.CommonName != "" },
+	"serialNumber":       func(n pkix.Name) bool { return n.SerialNumber != "" },
+	"organization":       func(n pkix.Name) bool { return len(n.Organization) > 0 },
+	"organizationalUnit": func(n pkix.Name) bool { return len(n.OrganizationalUnit) > 0 },
+	"country":            func(n pkix.Name) bool { return len(n.Country) > 0 },
+	"locality":           func(n pkix.Name) bool { return len(n.Locality) > 0 },
+	"province":           func(n pkix.Name) bool { return len(n.Province) > 0 },
+	"streetAddress":      func(n pkix.Name) bool { return len(n.StreetAddress) > 0 },
+	"postalCode":         func(n pkix.Name) bool { return len(n.PostalCode) > 0 },
+}
+
+// ParseProfileTemplate parses a common name or SAN template of a profile
+func ParseProfileTemplate(value string) (*template.Template, error) {
+	tmpl, err := template.New(value).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q: %v", value, err)
+	}
+	return tmpl, nil
+}
+
+type RequesterAuthorizationConfig struct {
+	SubjectAltNames bool `json:"subjectAltNames,omitempty"`
+}
+
+// LocalCAConfig loads the CA key and certificate either from files or from a kubernetes.io/tls Secret
+type LocalCAConfig struct {
+	CertFile        string `json:"certFile,omitempty"`
+	KeyFile         string `json:"keyFile,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+}
+
+type SPIFFEConfig struct {
+	TrustDomain  string          `json:"trustDomain"`
+	MaxTTL       metav1.Duration `json:"maxTTL"`
+	AllowDNSSANs bool            `json:"allowDNSSANs,omitempty"`
+	AllowIPSANs  bool            `json:"allowIPSANs,omitempty"`
+}
+
+type ControllerConfig struct {
+	Workers      int             `json:"workers"`
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+	// Polling period of the configuration, authentication, and policy files, disabled if zero
+	ReloadPeriod metav1.Duration   `json:"reloadPeriod"`
+	RateLimiter  RateLimiterConfig `json:"rateLimiter"`
+	// Watch the CSRs of all signers instead of filtering them by the spec.signerName of the configured signers
+	WatchAllSigners bool `json:"watchAllSigners,omitempty"`
+	// Maximum time waited on shutdown for the in-flight syncs to complete
+	ShutdownGracePeriod metav1.Duration      `json:"shutdownGracePeriod"`
+	LeaderElection      LeaderElectionConfig `json:"leaderElection"`
+	IssuanceCache       IssuanceCacheConfig  `json:"issuanceCache"`
+}
+
+// IssuanceCacheConfig configures the cache of the certificates issued for each CSR, which are published again
+// instead of signing new ones when the status update of a CSR fails
+type IssuanceCacheConfig struct {
+	// Time the certificates are cached, disabled if zero
+	TTL metav1.Duration `json:"ttl"`
+	// Path of the file persisting the cache across restarts, kept in memory if empty
+	Path string `json:"path,omitempty"`
+}
+
+// LeaderElectionConfig configures the Lease electing the replica that runs the controllers. The admission
+// webhook and the metrics server run on all the replicas
+type LeaderElectionConfig struct {
+	Enabled        bool            `json:"enabled"`
+	LeaseName      string          `json:"leaseName"`
+	LeaseNamespace string          `json:"leaseNamespace,omitempty"`
+	LeaseDuration  metav1.Duration `json:"leaseDuration"`
+	RenewDeadline  metav1.Duration `json:"renewDeadline"`
+	RetryPeriod    metav1.Duration `json:"retryPeriod"`
+}
+
+// RateLimiterConfig configures the workqueues of the controllers, retrying each item with an exponential
+// backoff between baseDelay and maxDelay, and processing at most qps items per second overall
+type RateLimiterConfig struct {
+	BaseDelay metav1.Duration `json:"baseDelay"`
+	MaxDelay  metav1.Duration `json:"maxDelay"`
+	QPS       float64         `json:"qps"`
+	Burst     int             `json:"burst"`
+}
+
+type CertificateRequestsConfig struct {
+	Enabled    bool   `json:"enabled"`
+	SignerName string `json:"signerName"`
 }
 
+type ManagedSecretsConfig struct {
+	Enabled         bool    `json:"enabled"`
+	SignerName      string  `json:"signerName"`
+	RenewalFraction float64 `json:"renewalFraction"`
+}
+
+type WebhookConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Address    string `json:"address"`
+	CertFile   string `json:"certFile,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+	Image      string `json:"image,omitempty"`
+	SignerName string `json:"signerName"`
+}
+
+type AuditConfig struct {
+	// Path of the JSON lines audit log, or - for stdout. Auditing is disabled if empty
+	Path string `json:"path,omitempty"`
+	// HTTP endpoints notified of the records matching their filters
+	Webhooks []AuditWebhookConfig `json:"webhooks,omitempty"`
+}
+
+// AuditWebhookConfig configures an HTTP endpoint receiving audit records as JSON. Empty filters match any value
+type AuditWebhookConfig struct {
+	URL string `json:"url"`
+	// Path of a file containing the key of the HMAC-SHA256 signature of the payload
+	SecretFile string   `json:"secretFile,omitempty"`
+	Signers    []string `json:"signers,omitempty"`
+	Outcomes   []string `json:"outcomes,omitempty"`
+	Profiles   []string `json:"profiles,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"`
+	// Notify rejections only when the same requester had at least minRejections rejections within rejectionWindow
+	MinRejections   int             `json:"minRejections,omitempty"`
+	RejectionWindow metav1.Duration `json:"rejectionWindow,omitempty"`
+	Timeout         metav1.Duration `json:"timeout,omitempty"`
+	MaxRetries      int             `json:"maxRetries,omitempty"`
+	QueueSize       int             `json:"queueSize,omitempty"`
+}
+
+type InventoryConfig struct {
+	// Windows of the gauges of the certificates expiring soon
+	ExpiringWithin []metav1.Duration `json:"expiringWithin,omitempty"`
+}
+
+type MetricsConfig struct {
+	// Address of the Prometheus metrics server, disabled if empty
+	Address string `json:"address,omitempty"`
+}
+
+type TracingConfig struct {
+	// URL of the OTLP/HTTP endpoint receiving the spans, e.g., http://otel-collector:4318. Tracing is
+	// disabled if empty
+	Endpoint      string  `json:"endpoint,omitempty"`
+	SamplingRatio float64 `json:"samplingRatio"`
+}
+
+// flagValues holds the values of command line flags, which override the configuration file and the
+// environment variables only when explicitly set
+type flagValues struct {
+	auditLog            string
+	authorizeRequesters bool
+	certificateRequests bool
+	kubeconfig          string
+	managedSecrets      bool
+	renewalFraction     float64
+	signingDuration     time.Duration
+	signingPolicy       string
+	spiffeAllowDNSSANs  bool
+	spiffeAllowIPSANs   bool
+	spiffeMaxTTL        time.Duration
+	spiffeTrustDomain   string
+	vaultAddress        string
+	vaultAuthConfig     string
+	vaultPki            string
+	vaultRole           string
+	webhook             bool
+	webhookAddress      string
+	webhookCertFile     string
+	webhookKeyFile      string
+	webhookImage        string
+	workers             int
+	resyncPeriod        time.Duration
+	reloadPeriod        time.Duration
+	tracingEndpoint     string
+	tracingRatio        float64
+	rateLimitBaseDelay  time.Duration
+	rateLimitMaxDelay   time.Duration
+	rateLimitQPS        float64
+	rateLimitBurst      int
+	watchAllSigners     bool
+	metricsAddress      string
+	shutdownGracePeriod time.Duration
+	leaderElect         bool
+	leaderElectLease    string
+	leaderElectNS       string
+	revokeToken         bool
+	issuanceCacheTTL    time.Duration
+	issuanceCachePath   string
+	expiringWithin      []time.Duration
+}
+
+const (
+	defaultSigningDuration = 365 * 24 * time.Hour
+	defaultSPIFFEMaxTTL    = time.Hour
+	defaultWebhookTimeout  = 10 * time.Second
+	defaultWebhookRetries  = 5
+	defaultWebhookQueue    = 1000
+)
+
 func NewConfig() *Config {
-	envDuration := os.Getenv("SIGNING_DURATION")
-	signingDuration, err := time.ParseDuration(envDuration)
+	return &Config{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		Controller: ControllerConfig{
+			Workers:      5,
+			ResyncPeriod: metav1.Duration{Duration: 5 * time.Minute},
+			ReloadPeriod: metav1.Duration{Duration: 30 * time.Second},
+			RateLimiter: RateLimiterConfig{
+				BaseDelay: metav1.Duration{Duration: 200 * time.Millisecond},
+				MaxDelay:  metav1.Duration{Duration: 1000 * time.Second},
+				QPS:       10,
+				Burst:     100,
+			},
+			ShutdownGracePeriod: metav1.Duration{Duration: 30 * time.Second},
+			LeaderElection: LeaderElectionConfig{
+				LeaseName:     "vault-signer",
+				LeaseDuration: metav1.Duration{Duration: 15 * time.Second},
+				RenewDeadline: metav1.Duration{Duration: 10 * time.Second},
+				RetryPeriod:   metav1.Duration{Duration: 2 * time.Second},
+			},
+			IssuanceCache: IssuanceCacheConfig{
+				TTL: metav1.Duration{Duration: time.Hour},
+			},
+		},
+		CertificateRequests: CertificateRequestsConfig{
+			SignerName: api.VaultSignerName,
+		},
+		ManagedSecrets: ManagedSecretsConfig{
+			SignerName:      api.VaultSignerName,
+			RenewalFraction: 2.0 / 3.0,
+		},
+		Webhook: WebhookConfig{
+			Address:    ":9443",
+			SignerName: api.VaultSignerName,
+		},
+		Tracing: TracingConfig{
+			SamplingRatio: 1,
+		},
+		Metrics: MetricsConfig{
+			Address: ":8080",
+		},
+		Inventory: InventoryConfig{
+			ExpiringWithin: []metav1.Duration{{Duration: 7 * 24 * time.Hour}, {Duration: 30 * 24 * time.Hour}},
+		},
+		file: os.Getenv("VAULT_SIGNER_CONFIG"),
+		flags: flagValues{
+			renewalFraction:     2.0 / 3.0,
+			signingDuration:     defaultSigningDuration,
+			spiffeMaxTTL:        defaultSPIFFEMaxTTL,
+			webhookAddress:      ":9443",
+			workers:             5,
+			resyncPeriod:        5 * time.Minute,
+			reloadPeriod:        30 * time.Second,
+			tracingRatio:        1,
+			metricsAddress:      ":8080",
+			rateLimitBaseDelay:  200 * time.Millisecond,
+			rateLimitMaxDelay:   1000 * time.Second,
+			rateLimitQPS:        10,
+			rateLimitBurst:      100,
+			shutdownGracePeriod: 30 * time.Second,
+			leaderElectLease:    "vault-signer",
+			issuanceCacheTTL:    time.Hour,
+			expiringWithin:      []time.Duration{7 * 24 * time.Hour, 30 * 24 * time.Hour},
+		},
+	}
+}
+
+// Load builds the configuration from the configuration file, the environment variables, and the
+// command line flags, in increasing order of precedence
+func (c *Config) Load(fs *pflag.FlagSet) error {
+	if c.file != "" {
+		if err := c.loadFile(c.file); err != nil {
+			return err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return err
+	}
+	c.loadFlags(fs)
+	c.setDefaults()
+	return nil
+}
+
+func (c *Config) File() string {
+	return c.file
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if envDuration != "" {
-			klog.Infof("failed to parse SIGNING_DURATION: %v", err)
+		return fmt.Errorf("unable to read configuration file %s: %v", path, err)
+	}
+	c.TypeMeta = metav1.TypeMeta{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("unable to parse configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	lookupBool := func(name string, set func(bool)) {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse %s: %v", name, err))
+				return
+			}
+			set(b)
+		}
+	}
+	lookupString := func(name string, set func(string)) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			set(value)
 		}
-		signingDuration = 365 * 24 * time.Hour
+	}
+	lookupDuration := func(name string, set func(time.Duration)) {
+		lookupString(name, func(value string) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse %s: %v", name, err))
+				return
+			}
+			set(d)
+		})
 	}
 
-	return &Config{
-		Kubeconfig:      os.Getenv("KUBECONFIG"),
-		SigningDuration: metav1.Duration{Duration: signingDuration},
-		VaultAddress:    os.Getenv("VAULT_ADDR"),
-		VaultAuthConfig: os.Getenv("VAULT_AUTH_CONFIG"),
-		VaultPki:        os.Getenv("VAULT_PKI"),
-		VaultRole:       os.Getenv("VAULT_ROLE"),
+	lookupString("KUBECONFIG", func(v string) { c.Kubeconfig = v })
+	lookupString("VAULT_ADDR", func(v string) { c.Vault.Address = v })
+	lookupString("VAULT_AUTH_CONFIG", func(v string) { c.Vault.AuthConfig = v })
+	lookupString("VAULT_PKI", func(v string) { c.defaultSigner().Pki = v })
+	lookupString("VAULT_ROLE", func(v string) { c.defaultSigner().Role = v })
+	lookupDuration("SIGNING_DURATION", func(v time.Duration) { c.defaultSigner().SigningDuration.Duration = v })
+	lookupString("POD_NAMESPACE", func(v string) {
+		if c.Controller.LeaderElection.LeaseNamespace == "" {
+			c.Controller.LeaderElection.LeaseNamespace = v
+		}
+	})
+	lookupString("SIGNING_POLICY", func(v string) { c.SigningPolicy = v })
+	lookupString("SPIFFE_TRUST_DOMAIN", func(v string) { c.defaultSPIFFE().TrustDomain = v })
+	lookupBool("CERTIFICATE_REQUESTS", func(v bool) { c.CertificateRequests.Enabled = v })
+	lookupBool("MANAGED_SECRETS", func(v bool) { c.ManagedSecrets.Enabled = v })
+	lookupString("RENEWAL_FRACTION", func(v string) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse RENEWAL_FRACTION: %v", err))
+			return
+		}
+		c.ManagedSecrets.RenewalFraction = f
+	})
+	lookupString("AUDIT_LOG", func(v string) { c.Audit.Path = v })
+	lookupBool("WEBHOOK", func(v bool) { c.Webhook.Enabled = v })
+	lookupString("WEBHOOK_CERT_FILE", func(v string) { c.Webhook.CertFile = v })
+	lookupString("WEBHOOK_KEY_FILE", func(v string) { c.Webhook.KeyFile = v })
+	lookupString("WEBHOOK_IMAGE", func(v string) { c.Webhook.Image = v })
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment variables: %v", errs)
 	}
+	return nil
 }
 
-func (c *Config) Validate() error {
-	var errorsFound bool
+func (c *Config) loadFlags(fs *pflag.FlagSet) {
+	f := &c.flags
+	changed := func(name string) bool {
+		return fs != nil && fs.Changed(name)
+	}
 
-	if c.VaultAddress == "" {
-		errorsFound = true
-		klog.Errorf("please specify --vault-address or set the VAULT_ADDR environment variable")
+	if changed("kubeconfig") {
+		c.Kubeconfig = f.kubeconfig
+	}
+	if changed("vault-address") {
+		c.Vault.Address = f.vaultAddress
+	}
+	if changed("vault-auth-config") {
+		c.Vault.AuthConfig = f.vaultAuthConfig
+	}
+	if changed("vault-pki") {
+		c.defaultSigner().Pki = f.vaultPki
+	}
+	if changed("vault-role") {
+		c.defaultSigner().Role = f.vaultRole
+	}
+	if changed("signing-duration") {
+		c.defaultSigner().SigningDuration.Duration = f.signingDuration
+	}
+	if changed("signing-policy") {
+		c.SigningPolicy = f.signingPolicy
+	}
+	if changed("authorize-requesters") {
+		if f.authorizeRequesters {
+			c.defaultSigner().RequesterAuthorization = &RequesterAuthorizationConfig{}
+		} else {
+			c.defaultSigner().RequesterAuthorization = nil
+		}
+	}
+	if changed("spiffe-trust-domain") {
+		c.defaultSPIFFE().TrustDomain = f.spiffeTrustDomain
+	}
+	// the other SPIFFE flags only refine an existing profile
+	if s := c.Signer(api.VaultSignerName); s != nil && s.SPIFFE != nil {
+		if changed("spiffe-max-ttl") {
+			s.SPIFFE.MaxTTL.Duration = f.spiffeMaxTTL
+		}
+		if changed("spiffe-allow-dns-sans") {
+			s.SPIFFE.AllowDNSSANs = f.spiffeAllowDNSSANs
+		}
+		if changed("spiffe-allow-ip-sans") {
+			s.SPIFFE.AllowIPSANs = f.spiffeAllowIPSANs
+		}
+	}
+	if changed("workers") {
+		c.Controller.Workers = f.workers
+	}
+	if changed("resync-period") {
+		c.Controller.ResyncPeriod.Duration = f.resyncPeriod
+	}
+	if changed("reload-period") {
+		c.Controller.ReloadPeriod.Duration = f.reloadPeriod
+	}
+	if changed("rate-limit-base-delay") {
+		c.Controller.RateLimiter.BaseDelay.Duration = f.rateLimitBaseDelay
+	}
+	if changed("rate-limit-max-delay") {
+		c.Controller.RateLimiter.MaxDelay.Duration = f.rateLimitMaxDelay
+	}
+	if changed("rate-limit-qps") {
+		c.Controller.RateLimiter.QPS = f.rateLimitQPS
+	}
+	if changed("rate-limit-burst") {
+		c.Controller.RateLimiter.Burst = f.rateLimitBurst
+	}
+	if changed("watch-all-signers") {
+		c.Controller.WatchAllSigners = f.watchAllSigners
+	}
+	if changed("shutdown-grace-period") {
+		c.Controller.ShutdownGracePeriod.Duration = f.shutdownGracePeriod
+	}
+	if changed("leader-elect") {
+		c.Controller.LeaderElection.Enabled = f.leaderElect
+	}
+	if changed("leader-elect-lease-name") {
+		c.Controller.LeaderElection.LeaseName = f.leaderElectLease
+	}
+	if changed("leader-elect-namespace") {
+		c.Controller.LeaderElection.LeaseNamespace = f.leaderElectNS
+	}
+	if changed("issuance-cache-ttl") {
+		c.Controller.IssuanceCache.TTL.Duration = f.issuanceCacheTTL
+	}
+	if changed("issuance-cache-path") {
+		c.Controller.IssuanceCache.Path = f.issuanceCachePath
+	}
+	if changed("vault-revoke-token-on-exit") {
+		c.Vault.RevokeTokenOnExit = f.revokeToken
+	}
+	if changed("certificate-requests") {
+		c.CertificateRequests.Enabled = f.certificateRequests
+	}
+	if changed("managed-secrets") {
+		c.ManagedSecrets.Enabled = f.managedSecrets
 	}
-	if c.VaultAuthConfig == "" {
-		errorsFound = true
-		klog.Errorf("please specify --vault-auth-config or set the VAULT_AUTH_CONFIG environment variable")
+	if changed("renewal-fraction") {
+		c.ManagedSecrets.RenewalFraction = f.renewalFraction
 	}
-	if c.VaultPki == "" {
-		errorsFound = true
-		klog.Errorf("please specify --vault-pki or set the VAULT_PKI environment variable")
+	if changed("audit-log") {
+		c.Audit.Path = f.auditLog
 	}
-	if c.VaultRole == "" {
-		errorsFound = true
-		klog.Errorf("please specify --vault-role or set the VAULT_ROLE environment variable")
+	if changed("metrics-address") {
+		c.Metrics.Address = f.metricsAddress
 	}
+	if changed("inventory-expiring-within") {
+		c.Inventory.ExpiringWithin = nil
+		for _, d := range f.expiringWithin {
+			c.Inventory.ExpiringWithin = append(c.Inventory.ExpiringWithin, metav1.Duration{Duration: d})
+		}
+	}
+	if changed("tracing-endpoint") {
+		c.Tracing.Endpoint = f.tracingEndpoint
+	}
+	if changed("tracing-sampling-ratio") {
+		c.Tracing.SamplingRatio = f.tracingRatio
+	}
+	if changed("webhook") {
+		c.Webhook.Enabled = f.webhook
+	}
+	if changed("webhook-address") {
+		c.Webhook.Address = f.webhookAddress
+	}
+	if changed("webhook-cert-file") {
+		c.Webhook.CertFile = f.webhookCertFile
+	}
+	if changed("webhook-key-file") {
+		c.Webhook.KeyFile = f.webhookKeyFile
+	}
+	if changed("webhook-image") {
+		c.Webhook.Image = f.webhookImage
+	}
+}
+
+func (c *Config) setDefaults() {
+	for i := range c.Signers {
+		if c.Signers[i].SigningDuration.Duration == 0 {
+			c.Signers[i].SigningDuration.Duration = defaultSigningDuration
+		}
+		if spiffe := c.Signers[i].SPIFFE; spiffe != nil && spiffe.MaxTTL.Duration == 0 {
+			spiffe.MaxTTL.Duration = defaultSPIFFEMaxTTL
+		}
+	}
+	for i := range c.Audit.Webhooks {
+		w := &c.Audit.Webhooks[i]
+		if w.Timeout.Duration == 0 {
+			w.Timeout.Duration = defaultWebhookTimeout
+		}
+		if w.MaxRetries == 0 {
+			w.MaxRetries = defaultWebhookRetries
+		}
+		if w.QueueSize == 0 {
+			w.QueueSize = defaultWebhookQueue
+		}
+	}
+}
+
+// defaultSigner returns the configuration of the unito.it/vault-signer signer, which is the target of the
+// signer-related flags and environment variables
+func (c *Config) defaultSigner() *SignerConfig {
+	if s := c.Signer(api.VaultSignerName); s != nil {
+		return s
+	}
+	c.Signers = append(c.Signers, SignerConfig{Name: api.VaultSignerName})
+	return &c.Signers[len(c.Signers)-1]
+}
+
+func (c *Config) defaultSPIFFE() *SPIFFEConfig {
+	s := c.defaultSigner()
+	if s.SPIFFE == nil {
+		s.SPIFFE = &SPIFFEConfig{}
+	}
+	return s.SPIFFE
+}
+
+// UsesVault reports whether any signer is backed by Vault
+func (c *Config) UsesVault() bool {
+	for _, s := range c.Signers {
+		if s.Local == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator creates the Vault authenticator from the inline configuration or the authentication file
+func (c *Config) Authenticator() (*vault.Authenticator, error) {
+	if c.Vault.Auth != nil {
+		return vault.NewAuthenticatorFromConfig(c.Vault.Auth), nil
+	}
+	return vault.NewAuthenticator(c.Vault.AuthConfig)
+}
 
-	if errorsFound {
-		return fmt.Errorf("failed to validate input parameters")
+// Policies loads the signing policies, if any
+func (c *Config) Policies() (map[string]*policy.Policy, error) {
+	if c.SigningPolicy == "" {
+		return nil, nil
+	}
+	return policy.LoadFile(c.SigningPolicy)
+}
+
+func (c *Config) Signer(name string) *SignerConfig {
+	for i := range c.Signers {
+		if c.Signers[i].Name == name {
+			return &c.Signers[i]
+		}
 	}
 	return nil
 }
 
 func (c *Config) AddFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Absolute path to the kubeconfig file. If the service is running inside a Pod, this option is not necessary: the in-cluster config will be used by default.")
-	fs.DurationVar(&c.SigningDuration.Duration, "signing-duration", c.SigningDuration.Duration, "The length of duration signed certificates will be given.")
-	fs.StringVar(&c.VaultAddress, "vault-address", c.VaultAddress, "Address of the Vault cluster.")
-	fs.StringVar(&c.VaultAuthConfig, "vault-auth-config", c.VaultAuthConfig, "Path of the Vault authentication configuration file.")
-	fs.StringVar(&c.VaultPki, "vault-pki", c.VaultPki, "Path of the Vault PKI secret mount used to generate the CA.")
-	fs.StringVar(&c.VaultRole, "vault-role", c.VaultRole, "Name of the Vault role used to sign the certificates.")
+	f := &c.flags
+	fs.StringVar(&c.file, "config", c.file, "Path of the YAML configuration file. Environment variables and command line flags override its values.")
+	fs.StringVar(&f.auditLog, "audit-log", f.auditLog, "Path of the JSON lines audit log of issued and rejected certificates, or - for stdout.")
+	fs.BoolVar(&f.authorizeRequesters, "authorize-requesters", f.authorizeRequesters, "Require the requesters of CSRs to be allowed the request verb on the signer through SubjectAccessReviews.")
+	fs.BoolVar(&f.certificateRequests, "certificate-requests", f.certificateRequests, "Enable the controller for namespaced VaultCertificateRequest objects.")
+	fs.DurationSliceVar(&f.expiringWithin, "inventory-expiring-within", f.expiringWithin, "Windows of the metrics of the certificates expiring soon.")
+	fs.StringVar(&f.kubeconfig, "kubeconfig", f.kubeconfig, "Absolute path to the kubeconfig file. If the service is running inside a Pod, this option is not necessary: the in-cluster config will be used by default.")
+	fs.BoolVar(&f.managedSecrets, "managed-secrets", f.managedSecrets, "Enable the controller for Secrets with managed certificates.")
+	fs.StringVar(&f.metricsAddress, "metrics-address", f.metricsAddress, "Address of the Prometheus metrics server. Set to an empty string to disable it.")
+	fs.Float64Var(&f.renewalFraction, "renewal-fraction", f.renewalFraction, "Fraction of the certificate lifetime after which managed certificates are renewed.")
+	fs.IntVar(&f.workers, "workers", f.workers, "Number of concurrent workers of each controller.")
+	fs.DurationVar(&f.resyncPeriod, "resync-period", f.resyncPeriod, "Resync period of the informers.")
+	fs.DurationVar(&f.reloadPeriod, "reload-period", f.reloadPeriod, "Polling period of the configuration, Vault authentication, and signing policy files. Set to 0 to disable reloading.")
+	fs.DurationVar(&f.rateLimitBaseDelay, "rate-limit-base-delay", f.rateLimitBaseDelay, "Initial delay of the exponential backoff of failed items in the controller workqueues.")
+	fs.DurationVar(&f.rateLimitMaxDelay, "rate-limit-max-delay", f.rateLimitMaxDelay, "Maximum delay of the exponential backoff of failed items in the controller workqueues.")
+	fs.Float64Var(&f.rateLimitQPS, "rate-limit-qps", f.rateLimitQPS, "Maximum number of items per second processed by each controller workqueue.")
+	fs.IntVar(&f.rateLimitBurst, "rate-limit-burst", f.rateLimitBurst, "Burst of items processed by each controller workqueue.")
+	fs.BoolVar(&f.watchAllSigners, "watch-all-signers", f.watchAllSigners, "Watch the CSRs of all signers instead of filtering them by the configured signer names.")
+	fs.DurationVar(&f.shutdownGracePeriod, "shutdown-grace-period", f.shutdownGracePeriod, "Maximum time waited on shutdown for the in-flight syncs to complete.")
+	fs.BoolVar(&f.leaderElect, "leader-elect", f.leaderElect, "Run the controllers only on the replica holding the leader election Lease.")
+	fs.StringVar(&f.leaderElectLease, "leader-elect-lease-name", f.leaderElectLease, "Name of the leader election Lease.")
+	fs.StringVar(&f.leaderElectNS, "leader-elect-namespace", f.leaderElectNS, "Namespace of the leader election Lease. Defaults to the POD_NAMESPACE environment variable.")
+	fs.DurationVar(&f.issuanceCacheTTL, "issuance-cache-ttl", f.issuanceCacheTTL, "Time the certificates issued for each CSR are cached, to publish them again instead of signing new ones when the status update fails. Set to 0 to disable the cache.")
+	fs.StringVar(&f.issuanceCachePath, "issuance-cache-path", f.issuanceCachePath, "Path of the file persisting the issuance cache across restarts.")
+	fs.DurationVar(&f.signingDuration, "signing-duration", f.signingDuration, "The length of duration signed certificates will be given.")
+	fs.StringVar(&f.signingPolicy, "signing-policy", f.signingPolicy, "Path of an optional file containing CEL signing policies for each signer name.")
+	fs.StringVar(&f.spiffeTrustDomain, "spiffe-trust-domain", f.spiffeTrustDomain, "SPIFFE trust domain. If set, only SPIFFE X.509-SVIDs for the requesting ServiceAccount are signed.")
+	fs.DurationVar(&f.spiffeMaxTTL, "spiffe-max-ttl", f.spiffeMaxTTL, "Maximum duration of SPIFFE certificates.")
+	fs.BoolVar(&f.spiffeAllowDNSSANs, "spiffe-allow-dns-sans", f.spiffeAllowDNSSANs, "Allow DNS SANs in SPIFFE certificates.")
+	fs.BoolVar(&f.spiffeAllowIPSANs, "spiffe-allow-ip-sans", f.spiffeAllowIPSANs, "Allow IP SANs in SPIFFE certificates.")
+	fs.StringVar(&f.tracingEndpoint, "tracing-endpoint", f.tracingEndpoint, "URL of the OTLP/HTTP endpoint receiving the traces, e.g., http://otel-collector:4318. Tracing is disabled if empty.")
+	fs.Float64Var(&f.tracingRatio, "tracing-sampling-ratio", f.tracingRatio, "Fraction of the CSR syncs that are traced.")
+	fs.StringVar(&f.vaultAddress, "vault-address", f.vaultAddress, "Address of the Vault cluster.")
+	fs.StringVar(&f.vaultAuthConfig, "vault-auth-config", f.vaultAuthConfig, "Path of the Vault authentication configuration file.")
+	fs.BoolVar(&f.revokeToken, "vault-revoke-token-on-exit", f.revokeToken, "Revoke the Vault token on shutdown.")
+	fs.StringVar(&f.vaultPki, "vault-pki", f.vaultPki, "Path of the Vault PKI secret mount used to generate the CA.")
+	fs.StringVar(&f.vaultRole, "vault-role", f.vaultRole, "Name of the Vault role used to sign the certificates.")
+	fs.BoolVar(&f.webhook, "webhook", f.webhook, "Enable the mutating admission webhook that injects certificates into annotated Pods.")
+	fs.StringVar(&f.webhookAddress, "webhook-address", f.webhookAddress, "Address of the admission webhook server.")
+	fs.StringVar(&f.webhookCertFile, "webhook-cert-file", f.webhookCertFile, "Path of the TLS certificate of the admission webhook server.")
+	fs.StringVar(&f.webhookKeyFile, "webhook-key-file", f.webhookKeyFile, "Path of the TLS private key of the admission webhook server.")
+	fs.StringVar(&f.webhookImage, "webhook-image", f.webhookImage, "Image of the init container injected into annotated Pods.")
 }
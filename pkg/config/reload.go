@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// Files returns the files the configuration has been built from
+func (c *Config) Files() []string {
+	var files []string
+	for _, f := range []string{c.file, c.Vault.AuthConfig, c.SigningPolicy} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Reload builds and validates a new configuration from the same sources of the current one
+func (c *Config) Reload(fs *pflag.FlagSet) (*Config, error) {
+	next := NewConfig()
+	next.file = c.file
+	next.flags = c.flags
+	if err := next.Load(fs); err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// Reloader polls the configuration files and applies the new configuration when any of them changes.
+// Mounted Secrets and ConfigMaps are updated by the kubelet through symlink swaps, so files are compared
+// by content rather than by modification time
+type Reloader struct {
+	current *Config
+	fs      *pflag.FlagSet
+	apply   func(*Config) error
+	digests map[string][sha256.Size]byte
+}
+
+func NewReloader(c *Config, fs *pflag.FlagSet, apply func(*Config) error) *Reloader {
+	return &Reloader{
+		current: c,
+		fs:      fs,
+		apply:   apply,
+		digests: digests(c.Files()),
+	}
+}
+
+func (r *Reloader) Run(ctx context.Context) {
+	period := r.current.Controller.ReloadPeriod.Duration
+	if period == 0 {
+		return
+	}
+	wait.UntilWithContext(ctx, r.poll, period)
+}
+
+func (r *Reloader) poll(ctx context.Context) {
+	if !r.changed() {
+		return
+	}
+
+	next, err := r.current.Reload(r.fs)
+	if err != nil {
+		klog.Errorf("rejected configuration update, keeping the previous configuration: %v", err)
+		r.digests = digests(r.current.Files())
+		return
+	}
+	if err := r.apply(next); err != nil {
+		klog.Errorf("failed to apply configuration update, keeping the previous configuration: %v", err)
+		r.digests = digests(next.Files())
+		return
+	}
+
+	klog.Infof("reloaded configuration from %v", next.Files())
+	r.current = next
+	r.digests = digests(next.Files())
+}
+
+func (r *Reloader) changed() bool {
+	current := digests(r.current.Files())
+	if len(current) != len(r.digests) {
+		return true
+	}
+	for f, d := range current {
+		if r.digests[f] != d {
+			return true
+		}
+	}
+	return false
+}
+
+func digests(files []string) map[string][sha256.Size]byte {
+	result := make(map[string][sha256.Size]byte, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			// missing files are reported by the validation of the new configuration
+			continue
+		}
+		result[f] = sha256.Sum256(data)
+	}
+	return result
+}
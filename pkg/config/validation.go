@@ -0,0 +1,457 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/alpha-unito/k8s-vault-signer/pkg/policy"
+	vault "github.com/alpha-unito/k8s-vault-signer/pkg/vault/client"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var authTypes = []string{"approle", "kubernetes"}
+
+var auditOutcomes = []string{"Issued", "Rejected"}
+
+// Validate checks the whole configuration, returning an aggregate of all the errors found
+func (c *Config) Validate() error {
+	return c.validate().ToAggregate()
+}
+
+func (c *Config) validate() field.ErrorList {
+	var errs field.ErrorList
+
+	if c.file != "" {
+		if c.APIVersion != APIVersion {
+			errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{APIVersion}))
+		}
+		if c.Kind != Kind {
+			errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+		}
+	}
+
+	if c.UsesVault() {
+		errs = append(errs, c.validateVault(field.NewPath("vault"))...)
+	}
+
+	signersPath := field.NewPath("signers")
+	if len(c.Signers) == 0 {
+		errs = append(errs, field.Required(signersPath, "please specify at least one signer, or use --vault-pki and --vault-role"))
+	}
+	names := sets.New[string]()
+	for i, s := range c.Signers {
+		errs = append(errs, validateSigner(signersPath.Index(i), &s, names)...)
+	}
+
+	var policies map[string]*policy.Policy
+	if c.SigningPolicy != "" {
+		var err error
+		if policies, err = policy.LoadFile(c.SigningPolicy); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("signingPolicy"), c.SigningPolicy, err.Error()))
+		}
+	}
+	// the policies of unknown signers would never apply, e.g., because of a typo in the signer name
+	for _, name := range sets.List(sets.KeySet(policies)) {
+		if !names.Has(name) {
+			errs = append(errs, field.Invalid(field.NewPath("signingPolicy"), c.SigningPolicy, fmt.Sprintf("signing policy for signer %s, which is not configured", name)))
+		}
+	}
+	for i, s := range c.Signers {
+		if s.Verbatim && policies[s.Name].Empty() {
+			errs = append(errs, field.Required(field.NewPath("signingPolicy"), fmt.Sprintf("signer %s uses sign-verbatim, which requires signing policy rules", s.Name)))
+		}
+		if s.Verbatim && s.Local != nil {
+			errs = append(errs, field.Forbidden(signersPath.Index(i).Child("verbatim"), "may not be specified together with local"))
+		}
+	}
+
+	controllerPath := field.NewPath("controller")
+	if c.Controller.Workers <= 0 {
+		errs = append(errs, field.Invalid(controllerPath.Child("workers"), c.Controller.Workers, "must be greater than 0"))
+	}
+	if c.Controller.ResyncPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(controllerPath.Child("resyncPeriod"), c.Controller.ResyncPeriod.Duration.String(), "must not be negative"))
+	}
+	if c.Controller.ReloadPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(controllerPath.Child("reloadPeriod"), c.Controller.ReloadPeriod.Duration.String(), "must not be negative"))
+	}
+
+	rateLimiterPath := controllerPath.Child("rateLimiter")
+	if rl := c.Controller.RateLimiter; rl.BaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(rateLimiterPath.Child("baseDelay"), rl.BaseDelay.Duration.String(), "must be greater than 0"))
+	} else if rl.MaxDelay.Duration < rl.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(rateLimiterPath.Child("maxDelay"), rl.MaxDelay.Duration.String(), "must not be shorter than baseDelay"))
+	}
+	if c.Controller.RateLimiter.QPS <= 0 {
+		errs = append(errs, field.Invalid(rateLimiterPath.Child("qps"), c.Controller.RateLimiter.QPS, "must be greater than 0"))
+	}
+	if c.Controller.RateLimiter.Burst <= 0 {
+		errs = append(errs, field.Invalid(rateLimiterPath.Child("burst"), c.Controller.RateLimiter.Burst, "must be greater than 0"))
+	}
+
+	if c.Controller.ShutdownGracePeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(controllerPath.Child("shutdownGracePeriod"), c.Controller.ShutdownGracePeriod.Duration.String(), "must not be negative"))
+	}
+	if c.Controller.IssuanceCache.TTL.Duration < 0 {
+		errs = append(errs, field.Invalid(controllerPath.Child("issuanceCache", "ttl"), c.Controller.IssuanceCache.TTL.Duration.String(), "must not be negative"))
+	}
+	if le := c.Controller.LeaderElection; le.Enabled {
+		errs = append(errs, validateLeaderElection(controllerPath.Child("leaderElection"), &le)...)
+	}
+
+	if c.CertificateRequests.Enabled {
+		errs = append(errs, validateSignerReference(field.NewPath("certificateRequests", "signerName"), c.CertificateRequests.SignerName, names)...)
+	}
+
+	if c.ManagedSecrets.Enabled {
+		managedSecretsPath := field.NewPath("managedSecrets")
+		errs = append(errs, validateSignerReference(managedSecretsPath.Child("signerName"), c.ManagedSecrets.SignerName, names)...)
+		if f := c.ManagedSecrets.RenewalFraction; f <= 0 || f >= 1 {
+			errs = append(errs, field.Invalid(managedSecretsPath.Child("renewalFraction"), f, "must be between 0 and 1"))
+		}
+	}
+
+	for i, d := range c.Inventory.ExpiringWithin {
+		if d.Duration <= 0 {
+			errs = append(errs, field.Invalid(field.NewPath("inventory", "expiringWithin").Index(i), d.Duration.String(), "must be greater than 0"))
+		}
+	}
+
+	for i, w := range c.Audit.Webhooks {
+		errs = append(errs, validateAuditWebhook(field.NewPath("audit", "webhooks").Index(i), &w)...)
+	}
+
+	tracingPath := field.NewPath("tracing")
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, field.Invalid(tracingPath.Child("endpoint"), c.Tracing.Endpoint, "must be an absolute http or https URL"))
+		}
+	}
+	if r := c.Tracing.SamplingRatio; r < 0 || r > 1 {
+		errs = append(errs, field.Invalid(tracingPath.Child("samplingRatio"), r, "must be between 0 and 1"))
+	}
+
+	if c.Webhook.Enabled {
+		webhookPath := field.NewPath("webhook")
+		errs = append(errs, validateSignerReference(webhookPath.Child("signerName"), c.Webhook.SignerName, names)...)
+		if c.Webhook.Address == "" {
+			errs = append(errs, field.Required(webhookPath.Child("address"), "please specify --webhook-address"))
+		}
+		if c.Webhook.CertFile == "" {
+			errs = append(errs, field.Required(webhookPath.Child("certFile"), "please specify --webhook-cert-file or set the WEBHOOK_CERT_FILE environment variable"))
+		}
+		if c.Webhook.KeyFile == "" {
+			errs = append(errs, field.Required(webhookPath.Child("keyFile"), "please specify --webhook-key-file or set the WEBHOOK_KEY_FILE environment variable"))
+		}
+		if c.Webhook.Image == "" {
+			errs = append(errs, field.Required(webhookPath.Child("image"), "please specify --webhook-image or set the WEBHOOK_IMAGE environment variable"))
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateVault(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if c.Vault.Address == "" {
+		errs = append(errs, field.Required(path.Child("address"), "please specify --vault-address or set the VAULT_ADDR environment variable"))
+	} else if u, err := url.Parse(c.Vault.Address); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, field.Invalid(path.Child("address"), c.Vault.Address, "must be an absolute URL"))
+	}
+
+	switch {
+	case c.Vault.AuthConfig == "" && c.Vault.Auth == nil:
+		errs = append(errs, field.Required(path.Child("authConfig"), "please specify --vault-auth-config, set the VAULT_AUTH_CONFIG environment variable, or configure vault.auth"))
+	case c.Vault.AuthConfig != "" && c.Vault.Auth != nil:
+		errs = append(errs, field.Forbidden(path.Child("auth"), "may not be specified together with vault.authConfig"))
+	case c.Vault.Auth != nil:
+		errs = append(errs, validateAuth(path.Child("auth"), c.Vault.Auth)...)
+	default:
+		auth, err := vault.LoadAuthConfig(c.Vault.AuthConfig)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("authConfig"), c.Vault.AuthConfig, err.Error()))
+		} else {
+			errs = append(errs, validateAuth(path.Child("authConfig"), auth)...)
+		}
+	}
+
+	return errs
+}
+
+func validateAuth(path *field.Path, auth *vault.AuthConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	switch authType := auth.Global.AuthType; authType {
+	case "approle":
+		appRolePath := path.Child("appRole")
+		errs = append(errs, validateExactlyOne(appRolePath, map[string]string{
+			"roleId":     auth.AppRole.RoleId,
+			"roleIdFile": auth.AppRole.RoleIdFile,
+		})...)
+		errs = append(errs, validateExactlyOne(appRolePath, map[string]string{
+			"secretId":     auth.AppRole.SecretId,
+			"secretIdFile": auth.AppRole.SecretIdFile,
+			"secretIdEnv":  auth.AppRole.SecretIdEnv,
+		})...)
+	case "kubernetes":
+		if auth.Kubernetes.RoleName == "" {
+			errs = append(errs, field.Required(path.Child("kubernetes", "roleName"), ""))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("global", "authType"), authType, authTypes))
+	}
+
+	return errs
+}
+
+// validateExactlyOne checks that exactly one of the alternative fields is set
+func validateExactlyOne(path *field.Path, fields map[string]string) field.ErrorList {
+	names := sets.List(sets.KeySet(fields))
+	var set []string
+	for _, name := range names {
+		if fields[name] != "" {
+			set = append(set, name)
+		}
+	}
+	switch len(set) {
+	case 0:
+		return field.ErrorList{field.Required(path.Child(names[0]), fmt.Sprintf("please specify one of %s", strings.Join(names, ", ")))}
+	case 1:
+		return nil
+	default:
+		return field.ErrorList{field.Forbidden(path.Child(set[1]), fmt.Sprintf("may not be specified together with %s", set[0]))}
+	}
+}
+
+func validateSigner(path *field.Path, s *SignerConfig, names sets.Set[string]) field.ErrorList {
+	var errs field.ErrorList
+
+	if s.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	} else if names.Has(s.Name) {
+		errs = append(errs, field.Duplicate(path.Child("name"), s.Name))
+	} else {
+		names.Insert(s.Name)
+		for _, msg := range validateSignerName(s.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), s.Name, msg))
+		}
+	}
+	if s.Local != nil {
+		errs = append(errs, validateLocalCA(path, s)...)
+	} else {
+		if s.Pki == "" {
+			errs = append(errs, field.Required(path.Child("pki"), "please specify --vault-pki or set the VAULT_PKI environment variable"))
+		}
+		if s.Role == "" {
+			errs = append(errs, field.Required(path.Child("role"), "please specify --vault-role or set the VAULT_ROLE environment variable"))
+		}
+	}
+	if s.SigningDuration.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("signingDuration"), s.SigningDuration.Duration.String(), "must be greater than 0"))
+	}
+
+	if s.SPIFFE != nil {
+		spiffePath := path.Child("spiffe")
+		if s.SPIFFE.TrustDomain == "" {
+			errs = append(errs, field.Required(spiffePath.Child("trustDomain"), ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Subdomain(s.SPIFFE.TrustDomain) {
+				errs = append(errs, field.Invalid(spiffePath.Child("trustDomain"), s.SPIFFE.TrustDomain, msg))
+			}
+		}
+		// shorter requested durations are raised to the minimum, which would exceed the maximum
+		if s.SPIFFE.MaxTTL.Duration < MinCertificateDuration {
+			errs = append(errs, field.Invalid(spiffePath.Child("maxTTL"), s.SPIFFE.MaxTTL.Duration.String(), fmt.Sprintf("must be at least %s", MinCertificateDuration)))
+		}
+	}
+
+	if s.Limits != nil {
+		errs = append(errs, validateLimits(path.Child("limits"), s.Limits)...)
+	}
+
+	profileNames := sets.New[string]()
+	for i, p := range s.Profiles {
+		errs = append(errs, validateProfile(path.Child("profiles").Index(i), &p, profileNames)...)
+	}
+
+	return errs
+}
+
+func validateLimits(path *field.Path, l *SignerLimitsConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	if l.QPS < 0 {
+		errs = append(errs, field.Invalid(path.Child("qps"), l.QPS, "must not be negative"))
+	}
+	if l.Burst < 0 {
+		errs = append(errs, field.Invalid(path.Child("burst"), l.Burst, "must not be negative"))
+	}
+	if l.MaxInFlight < 0 {
+		errs = append(errs, field.Invalid(path.Child("maxInFlight"), l.MaxInFlight, "must not be negative"))
+	}
+	if cb := l.CircuitBreaker; cb != nil {
+		if cb.FailureThreshold <= 0 {
+			errs = append(errs, field.Invalid(path.Child("circuitBreaker", "failureThreshold"), cb.FailureThreshold, "must be greater than 0"))
+		}
+		if cb.OpenDuration.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("circuitBreaker", "openDuration"), cb.OpenDuration.Duration.String(), "must be greater than 0"))
+		}
+	}
+
+	return errs
+}
+
+func validateAuditWebhook(path *field.Path, w *AuditWebhookConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	if w.URL == "" {
+		errs = append(errs, field.Required(path.Child("url"), ""))
+	} else if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, field.Invalid(path.Child("url"), w.URL, "must be an absolute http or https URL"))
+	}
+	for i, outcome := range w.Outcomes {
+		if !slices.Contains(auditOutcomes, outcome) {
+			errs = append(errs, field.NotSupported(path.Child("outcomes").Index(i), outcome, auditOutcomes))
+		}
+	}
+	if w.MinRejections < 0 {
+		errs = append(errs, field.Invalid(path.Child("minRejections"), w.MinRejections, "must not be negative"))
+	}
+	if w.RejectionWindow.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("rejectionWindow"), w.RejectionWindow.Duration.String(), "must not be negative"))
+	}
+	if w.Timeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("timeout"), w.Timeout.Duration.String(), "must be greater than 0"))
+	}
+	if w.MaxRetries < 0 {
+		errs = append(errs, field.Invalid(path.Child("maxRetries"), w.MaxRetries, "must not be negative"))
+	}
+	if w.QueueSize <= 0 {
+		errs = append(errs, field.Invalid(path.Child("queueSize"), w.QueueSize, "must be greater than 0"))
+	}
+
+	return errs
+}
+
+func validateProfile(path *field.Path, p *ProfileConfig, names sets.Set[string]) field.ErrorList {
+	var errs field.ErrorList
+
+	if p.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	} else if names.Has(p.Name) {
+		errs = append(errs, field.Duplicate(path.Child("name"), p.Name))
+	} else {
+		names.Insert(p.Name)
+	}
+
+	if p.MinDuration.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("minDuration"), p.MinDuration.Duration.String(), "must not be negative"))
+	}
+	if p.MaxDuration.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("maxDuration"), p.MaxDuration.Duration.String(), "must not be negative"))
+	} else if p.MaxDuration.Duration > 0 && p.MaxDuration.Duration < p.MinDuration.Duration {
+		errs = append(errs, field.Invalid(path.Child("maxDuration"), p.MaxDuration.Duration.String(), "must not be shorter than minDuration"))
+	}
+
+	for name, attrs := range map[string][]string{
+		"requiredSubjectAttributes":  p.RequiredSubjectAttributes,
+		"forbiddenSubjectAttributes": p.ForbiddenSubjectAttributes,
+	} {
+		for i, attr := range attrs {
+			if _, ok := SubjectAttributes[attr]; !ok {
+				errs = append(errs, field.NotSupported(path.Child(name).Index(i), attr, sets.List(sets.KeySet(SubjectAttributes))))
+			}
+		}
+	}
+
+	if p.CommonName != "" {
+		errs = append(errs, validateTemplate(path.Child("commonName"), p.CommonName)...)
+	}
+	for name, values := range map[string][]string{
+		"dnsNames":       p.DNSNames,
+		"uris":           p.URIs,
+		"ipAddresses":    p.IPAddresses,
+		"emailAddresses": p.EmailAddresses,
+	} {
+		for i, value := range values {
+			errs = append(errs, validateTemplate(path.Child(name).Index(i), value)...)
+		}
+	}
+
+	return errs
+}
+
+func validateTemplate(path *field.Path, value string) field.ErrorList {
+	if _, err := ParseProfileTemplate(value); err != nil {
+		return field.ErrorList{field.Invalid(path, value, err.Error())}
+	}
+	return nil
+}
+
+func validateLocalCA(path *field.Path, s *SignerConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	if s.Pki != "" {
+		errs = append(errs, field.Forbidden(path.Child("pki"), "may not be specified together with local"))
+	}
+	if s.Role != "" {
+		errs = append(errs, field.Forbidden(path.Child("role"), "may not be specified together with local"))
+	}
+
+	localPath := path.Child("local")
+	switch {
+	case s.Local.SecretName != "":
+		if s.Local.CertFile != "" || s.Local.KeyFile != "" {
+			errs = append(errs, field.Forbidden(localPath.Child("secretName"), "may not be specified together with certFile and keyFile"))
+		}
+		if s.Local.SecretNamespace == "" {
+			errs = append(errs, field.Required(localPath.Child("secretNamespace"), ""))
+		}
+	case s.Local.CertFile == "" || s.Local.KeyFile == "":
+		errs = append(errs, field.Required(localPath, "please specify either secretName or both certFile and keyFile"))
+	}
+
+	return errs
+}
+
+// validateSignerName checks that the name has the <domain>/<path> format required by Kubernetes
+func validateSignerName(name string) []string {
+	u, err := url.Parse("https://" + name)
+	if err != nil || u.Path == "" || u.Path == "/" {
+		return []string{"must be a fully qualified domain and path of the form 'example.com/signer-name'"}
+	}
+	return validation.IsDNS1123Subdomain(u.Host)
+}
+
+func validateSignerReference(path *field.Path, name string, names sets.Set[string]) field.ErrorList {
+	if !names.Has(name) {
+		return field.ErrorList{field.NotFound(path, name)}
+	}
+	return nil
+}
+
+func validateLeaderElection(path *field.Path, le *LeaderElectionConfig) field.ErrorList {
+	var errs field.ErrorList
+	if le.LeaseName == "" {
+		errs = append(errs, field.Required(path.Child("leaseName"), ""))
+	}
+	if le.LeaseNamespace == "" {
+		errs = append(errs, field.Required(path.Child("leaseNamespace"), "set it or the POD_NAMESPACE environment variable"))
+	}
+	if le.RetryPeriod.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("retryPeriod"), le.RetryPeriod.Duration.String(), "must be greater than 0"))
+	}
+	if le.RenewDeadline.Duration <= le.RetryPeriod.Duration {
+		errs = append(errs, field.Invalid(path.Child("renewDeadline"), le.RenewDeadline.Duration.String(), "must be longer than retryPeriod"))
+	}
+	if le.LeaseDuration.Duration <= le.RenewDeadline.Duration {
+		errs = append(errs, field.Invalid(path.Child("leaseDuration"), le.LeaseDuration.Duration.String(), "must be longer than renewDeadline"))
+	}
+	return errs
+}
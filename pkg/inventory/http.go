@@ -0,0 +1,36 @@
+package inventory
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ServeHTTP lists the entries matching the serial, signer, requester, subject, and expiringWithin query
+// parameters as JSON, e.g., /inventory?requester=system:serviceaccount:default:app&expiringWithin=168h
+func (i *Inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	q := Query{
+		Serial:    params.Get("serial"),
+		Signer:    params.Get("signer"),
+		Requester: params.Get("requester"),
+		Subject:   params.Get("subject"),
+	}
+	if v := params.Get("expiringWithin"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid expiringWithin: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.ExpiringWithin = d
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(i.Query(q))
+}
@@ -0,0 +1,187 @@
+package inventory
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Entry describes a live certificate issued by a signer
+type Entry struct {
+	Serial         string    `json:"serial"`
+	Signer         string    `json:"signer"`
+	CSR            string    `json:"csr"`
+	UID            types.UID `json:"uid"`
+	Requester      string    `json:"requester"`
+	Subject        string    `json:"subject"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+}
+
+// Query selects inventory entries. Empty fields match any entry
+type Query struct {
+	// Serial is the hex-encoded serial number, with or without colons
+	Serial string
+	Signer string
+	// Requester is the username of the requester of the CSR
+	Requester string
+	// Subject matches a substring of the subject DN or any of the SANs
+	Subject string
+	// ExpiringWithin selects the certificates expiring within the duration
+	ExpiringWithin time.Duration
+}
+
+// Inventory tracks the certificates issued by the signers until they expire. Certificates are added by the
+// controller when they are published, and by scanning the existing CSRs at startup
+type Inventory struct {
+	windows []time.Duration
+
+	lock    sync.RWMutex
+	entries map[string]*Entry
+}
+
+// New creates an inventory exposing the number of certificates expiring within each of the windows
+func New(windows []time.Duration) *Inventory {
+	return &Inventory{windows: windows, entries: make(map[string]*Entry)}
+}
+
+// Add records the leaf certificate in the status of the CSR. CSRs without a certificate are ignored
+func (i *Inventory) Add(csr *capi.CertificateSigningRequest) error {
+	if i == nil {
+		return nil
+	}
+	block, _ := pem.Decode(csr.Status.Certificate)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("unable to parse certificate of csr %q: %v", csr.Name, err)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil
+	}
+
+	e := &Entry{
+		Serial:         fmt.Sprintf("%x", cert.SerialNumber),
+		Signer:         csr.Spec.SignerName,
+		CSR:            csr.Name,
+		UID:            csr.UID,
+		Requester:      csr.Spec.Username,
+		Subject:        cert.Subject.String(),
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		e.IPAddresses = append(e.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		e.URIs = append(e.URIs, uri.String())
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.entries[e.Signer+"/"+e.Serial] = e
+	return nil
+}
+
+// Query returns the entries matching the query, sorted by expiration
+func (i *Inventory) Query(q Query) []Entry {
+	serial := strings.ToLower(strings.ReplaceAll(q.Serial, ":", ""))
+	subject := strings.ToLower(q.Subject)
+	now := time.Now()
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	entries := []Entry{}
+	for _, e := range i.entries {
+		if serial != "" && strings.TrimLeft(e.Serial, "0") != strings.TrimLeft(serial, "0") {
+			continue
+		}
+		if q.Signer != "" && e.Signer != q.Signer {
+			continue
+		}
+		if q.Requester != "" && e.Requester != q.Requester {
+			continue
+		}
+		if subject != "" && !e.matchesSubject(subject) {
+			continue
+		}
+		if q.ExpiringWithin > 0 && e.NotAfter.Sub(now) > q.ExpiringWithin {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].NotAfter.Before(entries[b].NotAfter)
+	})
+	return entries
+}
+
+func (e *Entry) matchesSubject(subject string) bool {
+	if strings.Contains(strings.ToLower(e.Subject), subject) {
+		return true
+	}
+	for _, sans := range [][]string{e.DNSNames, e.IPAddresses, e.URIs, e.EmailAddresses} {
+		if slices.ContainsFunc(sans, func(san string) bool { return strings.EqualFold(san, subject) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run periodically drops the expired certificates and updates the metrics, until the context is done
+func (i *Inventory) Run(ctx context.Context, period time.Duration) {
+	wait.UntilWithContext(ctx, func(context.Context) { i.refresh(time.Now()) }, period)
+}
+
+func (i *Inventory) refresh(now time.Time) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	certificates.Reset()
+	expiring.Reset()
+	expiry.Reset()
+	for key, e := range i.entries {
+		remaining := e.NotAfter.Sub(now)
+		if remaining <= 0 {
+			delete(i.entries, key)
+			continue
+		}
+		certificates.WithLabelValues(e.Signer).Inc()
+		expiry.WithLabelValues(e.Signer).Observe(remaining.Seconds())
+		// the windows without expiring certificates are reported as 0 rather than missing
+		for _, window := range i.windows {
+			if remaining <= window {
+				expiring.WithLabelValues(e.Signer, windowLabel(window)).Inc()
+			} else {
+				expiring.WithLabelValues(e.Signer, windowLabel(window)).Add(0)
+			}
+		}
+	}
+}
+
+// windowLabel formats the window in days, e.g., 7d, falling back to the duration if it is not a whole number of days
+func windowLabel(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
@@ -0,0 +1,37 @@
+package inventory
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const namespace = "vault_signer"
+
+// the metrics are recomputed from the inventory on each refresh, so the histogram describes the remaining
+// lifetime of the live certificates rather than accumulating observations
+var (
+	certificates = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "certificates",
+		Help:           "Number of live certificates issued by each signer.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer"})
+	expiring = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "certificates_expiring",
+		Help:           "Number of live certificates of each signer expiring within the window.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer", "within"})
+	expiry = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_seconds",
+		Help:      "Remaining lifetime of the live certificates of each signer.",
+		// 1h, 6h, 1d, 7d, 30d, 90d, 180d, 1y, 2y
+		Buckets:        []float64{3600, 21600, 86400, 604800, 2592000, 7776000, 15552000, 31536000, 63072000},
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer"})
+)
+
+func init() {
+	legacyregistry.MustRegister(certificates, expiring, expiry)
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// AllowedDomainsAnnotation lists additional DNS suffixes allowed for a namespace
+const AllowedDomainsAnnotation = "vault.unito.it/allowed-domains"
+
+var forbiddenUsages = []capi.KeyUsage{
+	capi.UsageAny,
+	capi.UsageCertSign,
+	capi.UsageCRLSign,
+}
+
+// NamespacePolicy restricts the identities that can be requested from a namespace
+type NamespacePolicy struct {
+	namespace   string
+	domains     []string
+	trustDomain string
+}
+
+// NewNamespacePolicy creates the policy of the namespace. URIs must be SPIFFE IDs of the trust domain under the
+// namespace path, and are not allowed if the trust domain is empty
+func NewNamespacePolicy(ns *v1.Namespace, trustDomain string) *NamespacePolicy {
+	domains := []string{
+		fmt.Sprintf("%s.svc", ns.Name),
+		fmt.Sprintf("%s.svc.cluster.local", ns.Name),
+	}
+	for _, domain := range strings.Split(ns.Annotations[AllowedDomainsAnnotation], ",") {
+		if domain = strings.TrimPrefix(strings.TrimSpace(domain), "."); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return &NamespacePolicy{namespace: ns.Name, domains: domains, trustDomain: trustDomain}
+}
+
+func (p *NamespacePolicy) ValidateRequest(cr *x509.CertificateRequest, usages []capi.KeyUsage) error {
+	var errs []error
+	for _, usage := range usages {
+		if slices.Contains(forbiddenUsages, usage) {
+			errs = append(errs, fmt.Errorf("usage %q is not allowed", usage))
+		}
+	}
+	if err := p.validateNames(cr.Subject, cr.DNSNames, cr.IPAddresses, cr.EmailAddresses, cr.URIs); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (p *NamespacePolicy) ValidateCertificate(cert *x509.Certificate) error {
+	var errs []error
+	if cert.IsCA {
+		errs = append(errs, fmt.Errorf("CA certificates are not allowed"))
+	}
+	if err := p.validateNames(cert.Subject, cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.URIs); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (p *NamespacePolicy) validateNames(subject pkix.Name, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	var errs []error
+	group := RequesterGroup(p.namespace)
+	if cn := subject.CommonName; cn != "" && !strings.HasPrefix(cn, group+":") && !p.allowedDNSName(cn) {
+		errs = append(errs, fmt.Errorf("common name %q must be a namespace DNS name or start with %q", cn, group+":"))
+	}
+	for _, o := range subject.Organization {
+		if o != group {
+			errs = append(errs, fmt.Errorf("organization %q is not allowed, only %q can be requested", o, group))
+		}
+	}
+	for _, name := range dnsNames {
+		if !p.allowedDNSName(name) {
+			errs = append(errs, fmt.Errorf("DNS name %q is not allowed for namespace %s", name, p.namespace))
+		}
+	}
+	if len(ips) > 0 {
+		errs = append(errs, fmt.Errorf("IP addresses are not allowed"))
+	}
+	if len(emails) > 0 {
+		errs = append(errs, fmt.Errorf("email addresses are not allowed"))
+	}
+	for _, uri := range uris {
+		if !p.allowedURI(uri) {
+			errs = append(errs, fmt.Errorf("URI %q is not allowed for namespace %s", uri, p.namespace))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (p *NamespacePolicy) allowedURI(uri *url.URL) bool {
+	if p.trustDomain == "" || uri.Scheme != "spiffe" || uri.Host != p.trustDomain {
+		return false
+	}
+	if uri.Opaque != "" || uri.User != nil || uri.RawQuery != "" || uri.Fragment != "" || path.Clean(uri.Path) != uri.Path {
+		return false
+	}
+	return strings.HasPrefix(uri.Path, fmt.Sprintf("/ns/%s/", p.namespace))
+}
+
+func (p *NamespacePolicy) allowedDNSName(name string) bool {
+	for _, domain := range p.domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func RequesterGroup(namespace string) string {
+	return fmt.Sprintf("system:vault:%s", namespace)
+}
+
+// RequesterGroups are the groups of the identity of the certificates requested by the objects of a namespace
+func RequesterGroups(namespace string) []string {
+	return []string{RequesterGroup(namespace), "system:authenticated"}
+}
+
+// RequesterUsername is the identity of the certificates requested by the named object of a namespace
+func RequesterUsername(namespace, name string) string {
+	return fmt.Sprintf("%s:%s", RequesterGroup(namespace), name)
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespacePolicy(t *testing.T) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team",
+		Annotations: map[string]string{AllowedDomainsAnnotation: "team.example.com, .apps.example.com"},
+	}}
+	tests := []struct {
+		name        string
+		trustDomain string
+		request     *x509.CertificateRequest
+		usages      []capi.KeyUsage
+		allowed     bool
+	}{
+		{name: "namespace DNS name", request: &x509.CertificateRequest{DNSNames: []string{"app.team.svc", "app.team.svc.cluster.local"}}, allowed: true},
+		{name: "annotated domain", request: &x509.CertificateRequest{DNSNames: []string{"team.example.com", "web.apps.example.com"}}, allowed: true},
+		{name: "foreign DNS name", request: &x509.CertificateRequest{DNSNames: []string{"app.other.svc"}}},
+		{name: "requester common name", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:vault:team:app", Organization: []string{"system:vault:team"}}}, allowed: true},
+		{name: "foreign organization", request: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "app.team.svc", Organization: []string{"system:masters"}}}},
+		{name: "IP address", request: &x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}},
+		{name: "forbidden usage", request: &x509.CertificateRequest{DNSNames: []string{"app.team.svc"}}, usages: []capi.KeyUsage{capi.UsageCertSign}},
+		{name: "SPIFFE ID", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://cluster.local/ns/team/sa/app"), allowed: true},
+		{name: "URI without trust domain", request: uriRequest(t, "spiffe://cluster.local/ns/team/sa/app")},
+		{name: "foreign trust domain", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://evil.example.com/ns/team/sa/app")},
+		{name: "foreign scheme", trustDomain: "cluster.local", request: uriRequest(t, "https://cluster.local/ns/team/sa/app")},
+		{name: "foreign namespace", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://cluster.local/ns/other/sa/app")},
+		{name: "path traversal", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://cluster.local/ns/team/../other/sa/app")},
+		{name: "port", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://cluster.local:8443/ns/team/sa/app")},
+		{name: "query", trustDomain: "cluster.local", request: uriRequest(t, "spiffe://cluster.local/ns/team/sa/app?x=y")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewNamespacePolicy(ns, tt.trustDomain).ValidateRequest(tt.request, tt.usages)
+			if tt.allowed && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("expected the request to be rejected")
+			}
+		})
+	}
+}
+
+func uriRequest(t *testing.T, uri string) *x509.CertificateRequest {
+	t.Helper()
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509.CertificateRequest{URIs: []*url.URL{u}}
+}
@@ -0,0 +1,223 @@
+package policy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+type Decision string
+
+const (
+	Sign Decision = "Sign"
+	Deny Decision = "Deny"
+	Fail Decision = "Fail"
+)
+
+type Rule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	Message    string `json:"message,omitempty"`
+}
+
+type SignerPolicy struct {
+	SignerName string `json:"signerName"`
+	Rules      []Rule `json:"rules"`
+}
+
+type Config struct {
+	Signers []SignerPolicy `json:"signers"`
+}
+
+type Result struct {
+	Decision Decision
+	Rule     string
+	Message  string
+}
+
+type Policy struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	program cel.Program
+}
+
+func LoadFile(path string) (map[string]*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing policy file %s: %v", path, err)
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse signing policy file %s: %v", path, err)
+	}
+
+	policies := make(map[string]*Policy, len(cfg.Signers))
+	for _, sp := range cfg.Signers {
+		if _, ok := policies[sp.SignerName]; ok {
+			return nil, fmt.Errorf("duplicate signing policy for signer %s", sp.SignerName)
+		}
+		p, err := NewPolicy(sp.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing policy for signer %s: %v", sp.SignerName, err)
+		}
+		policies[sp.SignerName] = p
+	}
+
+	return policies, nil
+}
+
+func NewPolicy(rules []Rule) (*Policy, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CEL environment: %v", err)
+	}
+
+	p := &Policy{}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		ast, iss := env.Compile(rule.Expression)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("unable to compile rule %s: %v", rule.Name, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("rule %s must evaluate to bool, got %s", rule.Name, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build program for rule %s: %v", rule.Name, err)
+		}
+		p.rules = append(p.rules, compiledRule{Rule: rule, program: program})
+	}
+
+	return p, nil
+}
+
+// Empty reports whether the policy has no rules, so that every request is signed
+func (p *Policy) Empty() bool {
+	return p == nil || len(p.rules) == 0
+}
+
+// Evaluate runs every rule against the CSR. The first rule evaluating to false
+// denies the request, while any evaluation error fails it.
+func (p *Policy) Evaluate(csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) Result {
+	if p.Empty() {
+		return Result{Decision: Sign}
+	}
+
+	vars, err := activation(csr, x509cr)
+	if err != nil {
+		return Result{Decision: Fail, Message: err.Error()}
+	}
+
+	for _, rule := range p.rules {
+		out, _, err := rule.program.Eval(vars)
+		if err != nil {
+			return Result{
+				Decision: Fail,
+				Rule:     rule.Name,
+				Message:  fmt.Sprintf("error evaluating rule %s: %v", rule.Name, err),
+			}
+		}
+		if out.Type() != types.BoolType {
+			return Result{
+				Decision: Fail,
+				Rule:     rule.Name,
+				Message:  fmt.Sprintf("rule %s returned %s instead of bool", rule.Name, out.Type().TypeName()),
+			}
+		}
+		if out != types.True {
+			message := rule.Message
+			if message == "" {
+				message = fmt.Sprintf("request rejected by rule %s", rule.Name)
+			}
+			return Result{Decision: Deny, Rule: rule.Name, Message: message}
+		}
+	}
+
+	return Result{Decision: Sign}
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("x509", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("username", cel.StringType),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("extra", cel.MapType(cel.StringType, cel.ListType(cel.StringType))),
+		ext.Strings(),
+	)
+}
+
+func activation(csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) (map[string]interface{}, error) {
+	request, err := runtime.DefaultUnstructuredConverter.ToUnstructured(csr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert csr %s: %v", csr.Name, err)
+	}
+
+	extra := make(map[string][]string, len(csr.Spec.Extra))
+	for k, v := range csr.Spec.Extra {
+		extra[k] = v
+	}
+
+	groups := csr.Spec.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	return map[string]interface{}{
+		"request":  request,
+		"x509":     x509Fields(x509cr),
+		"username": csr.Spec.Username,
+		"groups":   groups,
+		"extra":    extra,
+	}, nil
+}
+
+func x509Fields(cr *x509.CertificateRequest) map[string]interface{} {
+	ipAddresses := make([]string, 0, len(cr.IPAddresses))
+	for _, ip := range cr.IPAddresses {
+		ipAddresses = append(ipAddresses, ip.String())
+	}
+	uris := make([]string, 0, len(cr.URIs))
+	for _, uri := range cr.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return map[string]interface{}{
+		"subject": map[string]interface{}{
+			"commonName":         cr.Subject.CommonName,
+			"organization":       nonNil(cr.Subject.Organization),
+			"organizationalUnit": nonNil(cr.Subject.OrganizationalUnit),
+			"country":            nonNil(cr.Subject.Country),
+			"locality":           nonNil(cr.Subject.Locality),
+			"province":           nonNil(cr.Subject.Province),
+		},
+		"dnsNames":           nonNil(cr.DNSNames),
+		"emailAddresses":     nonNil(cr.EmailAddresses),
+		"ipAddresses":        ipAddresses,
+		"uris":               uris,
+		"publicKeyAlgorithm": cr.PublicKeyAlgorithm.String(),
+		"signatureAlgorithm": cr.SignatureAlgorithm.String(),
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRequest(t *testing.T, template *x509.CertificateRequest) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cr
+}
+
+func newCSR(username string, groups ...string) *capi.CertificateSigningRequest {
+	return &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: capi.CertificateSigningRequestSpec{
+			SignerName: "unito.it/vault-signer",
+			Username:   username,
+			Groups:     groups,
+			Usages:     []capi.KeyUsage{capi.UsageDigitalSignature, capi.UsageClientAuth},
+		},
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		err   string
+	}{
+		{
+			name:  "valid",
+			rules: []Rule{{Name: "cn", Expression: `x509.subject.commonName == username`}},
+		},
+		{
+			name:  "syntax error",
+			rules: []Rule{{Name: "broken", Expression: `x509.subject.commonName ==`}},
+			err:   "unable to compile rule broken",
+		},
+		{
+			name:  "undeclared variable",
+			rules: []Rule{{Name: "unknown", Expression: `namespace == "default"`}},
+			err:   "unable to compile rule unknown",
+		},
+		{
+			name:  "non-boolean output",
+			rules: []Rule{{Name: "string", Expression: `username`}},
+			err:   "rule string must evaluate to bool",
+		},
+		{
+			name:  "default rule name",
+			rules: []Rule{{Expression: `true`}, {Expression: `1`}},
+			err:   "rule rule-1 must evaluate to bool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicy(tt.rules)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	cr := newRequest(t, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "system:node:worker-1", Organization: []string{"system:nodes"}},
+		DNSNames: []string{"worker-1.cluster.local"},
+	})
+
+	tests := []struct {
+		name     string
+		rules    []Rule
+		csr      *capi.CertificateSigningRequest
+		decision Decision
+		rule     string
+		message  string
+	}{
+		{
+			name:     "no rules",
+			csr:      newCSR("system:node:worker-1"),
+			decision: Sign,
+		},
+		{
+			name: "all rules allow",
+			rules: []Rule{
+				{Name: "cn", Expression: `x509.subject.commonName == username`},
+				{Name: "dns", Expression: `x509.dnsNames.all(n, n.endsWith(".cluster.local"))`},
+				{Name: "usages", Expression: `"client auth" in request.spec.usages`},
+			},
+			csr:      newCSR("system:node:worker-1"),
+			decision: Sign,
+		},
+		{
+			name: "denied with message",
+			rules: []Rule{
+				{Name: "cn", Expression: `x509.subject.commonName == username`, Message: "common name must match the requester"},
+			},
+			csr:      newCSR("system:node:worker-2"),
+			decision: Deny,
+			rule:     "cn",
+			message:  "common name must match the requester",
+		},
+		{
+			name: "denied with default message",
+			rules: []Rule{
+				{Name: "cn", Expression: `x509.subject.commonName == username`},
+				{Name: "groups", Expression: `"system:nodes" in groups`},
+			},
+			csr:      newCSR("system:node:worker-1", "system:authenticated"),
+			decision: Deny,
+			rule:     "groups",
+			message:  "request rejected by rule groups",
+		},
+		{
+			name: "first denying rule wins",
+			rules: []Rule{
+				{Name: "first", Expression: `false`, Message: "first"},
+				{Name: "second", Expression: `false`, Message: "second"},
+			},
+			csr:      newCSR("system:node:worker-1"),
+			decision: Deny,
+			rule:     "first",
+			message:  "first",
+		},
+		{
+			name: "evaluation error fails",
+			rules: []Rule{
+				{Name: "extra", Expression: `extra["missing"][0] == "value"`},
+			},
+			csr:      newCSR("system:node:worker-1"),
+			decision: Fail,
+			rule:     "extra",
+			message:  "error evaluating rule extra",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.rules)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := p.Evaluate(tt.csr, cr)
+			if result.Decision != tt.decision {
+				t.Fatalf("expected decision %s, got %s (%s)", tt.decision, result.Decision, result.Message)
+			}
+			if result.Rule != tt.rule {
+				t.Errorf("expected rule %q, got %q", tt.rule, result.Rule)
+			}
+			if !strings.Contains(result.Message, tt.message) {
+				t.Errorf("expected message containing %q, got %q", tt.message, result.Message)
+			}
+		})
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var p *Policy
+	if !p.Empty() {
+		t.Error("nil policy must be empty")
+	}
+	if result := p.Evaluate(newCSR("user"), nil); result.Decision != Sign {
+		t.Errorf("nil policy must sign, got %s", result.Decision)
+	}
+}
@@ -0,0 +1,225 @@
+package signing
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"golang.org/x/time/rate"
+)
+
+// Limits caps the rate and the concurrency of the Sign calls of a signer, and opens a circuit breaker after
+// consecutive failures of the backend. Zero values disable the corresponding limit
+type Limits struct {
+	QPS         float64
+	Burst       int
+	MaxInFlight int
+	// FailureThreshold is the number of consecutive server errors or timeouts that opens the breaker
+	FailureThreshold int
+	// OpenDuration is the time the breaker stays open before letting a single trial call through
+	OpenDuration time.Duration
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+// CircuitOpenError is returned while the circuit breaker is open, so that the request is retried after
+// the breaker lets calls through again
+type CircuitOpenError struct {
+	Signer     string
+	retryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of signer %s is open, retrying in %s", e.Signer, e.retryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// LimitedSigner enforces the Limits around the Sign calls of another signer
+type LimitedSigner struct {
+	Signer
+	*limitState
+}
+
+// limitState is the rate limiter, the concurrency limit, and the circuit breaker of a signer
+type limitState struct {
+	name     string
+	limits   Limits
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+
+	lock     sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func NewLimitedSigner(name string, s Signer, limits Limits) *LimitedSigner {
+	ls := &limitState{name: name, limits: limits}
+	if limits.QPS > 0 {
+		burst := limits.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		ls.limiter = rate.NewLimiter(rate.Limit(limits.QPS), burst)
+	}
+	if limits.MaxInFlight > 0 {
+		ls.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+	breakerState.WithLabelValues(name).Set(float64(BreakerClosed))
+	return &LimitedSigner{Signer: s, limitState: ls}
+}
+
+// Limiters keeps the LimitedSigner of each signer name, so that the signers created again on configuration
+// reloads share the rate limiter, the concurrency limit, and the circuit breaker of the previous ones, unless
+// their limits changed
+type Limiters struct {
+	lock    sync.Mutex
+	signers map[string]*LimitedSigner
+}
+
+func NewLimiters() *Limiters {
+	return &Limiters{signers: make(map[string]*LimitedSigner)}
+}
+
+// Wrap enforces the limits around the signer
+func (l *Limiters) Wrap(name string, s Signer, limits Limits) *LimitedSigner {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if previous, ok := l.signers[name]; ok && previous.limits == limits {
+		return &LimitedSigner{Signer: s, limitState: previous.limitState}
+	}
+	ls := NewLimitedSigner(name, s, limits)
+	l.signers[name] = ls
+	return ls
+}
+
+func (s *LimitedSigner) Sign(ctx context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error) {
+	if err := s.allow(); err != nil {
+		return nil, err
+	}
+
+	if s.limiter != nil {
+		if err := s.limiter.Wait(ctx); err != nil {
+			s.cancelTrial()
+			return nil, fmt.Errorf("rate limit of signer %s: %v", s.name, err)
+		}
+	}
+	if s.inFlight != nil {
+		select {
+		case s.inFlight <- struct{}{}:
+			defer func() { <-s.inFlight }()
+		case <-ctx.Done():
+			s.cancelTrial()
+			return nil, fmt.Errorf("concurrency limit of signer %s: %v", s.name, ctx.Err())
+		}
+	}
+
+	signInFlight.WithLabelValues(s.name).Inc()
+	chain, err := s.Signer.Sign(ctx, csr, usage, extUsages, ttl)
+	signInFlight.WithLabelValues(s.name).Dec()
+
+	s.release(isBackendFailure(err))
+	return chain, err
+}
+
+// allow checks the breaker, letting a single trial call through once the open duration elapsed
+func (s *limitState) allow() error {
+	if s.limits.FailureThreshold <= 0 {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch s.state {
+	case BreakerOpen:
+		if wait := s.limits.OpenDuration - time.Since(s.openedAt); wait > 0 {
+			return &CircuitOpenError{Signer: s.name, retryAfter: wait}
+		}
+		s.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if s.trial {
+			return &CircuitOpenError{Signer: s.name, retryAfter: s.limits.OpenDuration}
+		}
+		s.trial = true
+	}
+	return nil
+}
+
+// release records the outcome of a call allowed by the breaker
+func (s *limitState) release(failed bool) {
+	if s.limits.FailureThreshold <= 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.state == BreakerHalfOpen && s.trial {
+		s.trial = false
+		if failed {
+			s.open()
+		} else {
+			s.failures = 0
+			s.setState(BreakerClosed)
+		}
+		return
+	}
+
+	if !failed {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.state == BreakerClosed && s.failures >= s.limits.FailureThreshold {
+		s.open()
+	}
+}
+
+// cancelTrial lets another call through the half-open breaker when the trial call did not reach the backend
+func (s *limitState) cancelTrial() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.trial = false
+}
+
+func (s *limitState) open() {
+	s.openedAt = time.Now()
+	s.failures = 0
+	s.setState(BreakerOpen)
+	breakerOpenings.WithLabelValues(s.name).Inc()
+}
+
+func (s *limitState) setState(state BreakerState) {
+	s.state = state
+	breakerState.WithLabelValues(s.name).Set(float64(state))
+}
+
+// isBackendFailure reports whether the error is a server error or a timeout of the backend, as opposed to
+// a rejection of the request
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rerr *vault.ResponseError
+	if errors.As(err, &rerr) {
+		return rerr.StatusCode >= http.StatusInternalServerError
+	}
+	var nerr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout())
+}
@@ -0,0 +1,46 @@
+package signing
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// failingSigner fails every call with a server error
+type failingSigner struct {
+	Signer
+}
+
+func (s *failingSigner) Sign(context.Context, *x509.CertificateRequest, x509.KeyUsage, []x509.ExtKeyUsage, time.Duration) ([]*x509.Certificate, error) {
+	return nil, &vault.ResponseError{StatusCode: http.StatusInternalServerError}
+}
+
+func TestLimitersKeepBreaker(t *testing.T) {
+	limits := Limits{FailureThreshold: 1, OpenDuration: time.Hour}
+	limiters := NewLimiters()
+	var oerr *CircuitOpenError
+
+	s := limiters.Wrap("vault", &failingSigner{}, limits)
+	if _, err := s.Sign(context.Background(), nil, 0, nil, time.Hour); errors.As(err, &oerr) {
+		t.Fatalf("expected the first call to reach the backend, got %v", err)
+	}
+
+	// a reload with the same limits keeps the open breaker
+	s = limiters.Wrap("vault", &failingSigner{}, limits)
+	if _, err := s.Sign(context.Background(), nil, 0, nil, time.Hour); !errors.As(err, &oerr) {
+		t.Errorf("expected the breaker to stay open across reloads, got %v", err)
+	}
+
+	// other signers and changed limits start from a closed breaker
+	for name, l := range map[string]Limits{"other": limits, "vault": {FailureThreshold: 2, OpenDuration: time.Hour}} {
+		s = limiters.Wrap(name, &failingSigner{}, l)
+		if _, err := s.Sign(context.Background(), nil, 0, nil, time.Hour); errors.As(err, &oerr) {
+			t.Errorf("expected a closed breaker for %s, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// backdate tolerates clock skew between the signer and the clients, as done by the Kubernetes signers
+const backdate = 5 * time.Minute
+
+// LocalSigner issues certificates with a CA key and certificate held in memory. It is meant for development
+// clusters and hermetic tests, where a Vault instance is not available
+type LocalSigner struct {
+	caCert *x509.Certificate
+	caPEM  []byte
+	caKey  crypto.Signer
+	maxTTL time.Duration
+}
+
+func NewLocalSigner(certPEM, keyPEM []byte, maxTTL time.Duration) (*LocalSigner, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("invalid CA certificate: PEM block type must be CERTIFICATE")
+	}
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA certificate: %v", err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("invalid CA certificate: %s is not a CA", caCert.Subject)
+	}
+
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA private key: %v", err)
+	}
+	caKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("invalid CA private key: unsupported key type %T", key)
+	}
+
+	return &LocalSigner{
+		caCert: caCert,
+		caPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		caKey:  caKey,
+		maxTTL: maxTTL,
+	}, nil
+}
+
+func NewLocalSignerFromFiles(certFile, keyFile string, maxTTL time.Duration) (*LocalSigner, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA private key: %v", err)
+	}
+	return NewLocalSigner(certPEM, keyPEM, maxTTL)
+}
+
+// NewLocalSignerFromSecret loads the CA from the tls.crt and tls.key entries of a Secret
+func NewLocalSignerFromSecret(ctx context.Context, kclient kubernetes.Interface, namespace, name string, maxTTL time.Duration) (*LocalSigner, error) {
+	secret, err := kclient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get CA secret %s/%s: %v", namespace, name, err)
+	}
+	return NewLocalSigner(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey], maxTTL)
+}
+
+func (s *LocalSigner) Validate(csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) error {
+	if usage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return fmt.Errorf("unable to sign csr for %s: forbidden key usage", csr.Subject.CommonName)
+	}
+	if s.maxTTL > 0 && ttl > s.maxTTL {
+		return fmt.Errorf("unable to sign csr for %s: ttl (%s) exceeds max ttl (%s)", csr.Subject.CommonName, ttl, s.maxTTL)
+	}
+	return nil
+}
+
+func (s *LocalSigner) Sign(_ context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error) {
+	if err := s.Validate(csr, usage, extUsages, ttl); err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate serial number: %v", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(s.caCert.NotAfter) {
+		notAfter = s.caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+		EmailAddresses:        csr.EmailAddresses,
+		URIs:                  csr.URIs,
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              notAfter,
+		KeyUsage:              usage,
+		ExtKeyUsage:           extUsages,
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign csr for %s: %v", csr.Subject.CommonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate generated for %s: %v", csr.Subject.CommonName, err)
+	}
+
+	return []*x509.Certificate{cert, s.caCert}, nil
+}
+
+func (s *LocalSigner) CA() ([]byte, error) {
+	return s.caPEM, nil
+}
@@ -0,0 +1,33 @@
+package signing
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const namespace = "vault_signer"
+
+var (
+	breakerState = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "circuit_breaker_state",
+		Help:           "State of the circuit breaker of each signer: 0 closed, 1 half-open, 2 open.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer"})
+	breakerOpenings = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      namespace,
+		Name:           "circuit_breaker_openings_total",
+		Help:           "Number of times the circuit breaker of each signer opened.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer"})
+	signInFlight = metrics.NewGaugeVec(&metrics.GaugeOpts{
+		Namespace:      namespace,
+		Name:           "sign_in_flight",
+		Help:           "Number of Sign calls of each signer waiting for the backend.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"signer"})
+)
+
+func init() {
+	legacyregistry.MustRegister(breakerState, breakerOpenings, signInFlight)
+}
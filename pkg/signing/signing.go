@@ -0,0 +1,68 @@
+package signing
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/alpha-unito/k8s-vault-signer/pkg/config"
+	"github.com/alpha-unito/k8s-vault-signer/pkg/vault/sign"
+	vault "github.com/hashicorp/vault/api"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// Signer issues certificates for parsed certificate requests
+type Signer interface {
+	// Validate checks the requested usages and ttl against the constraints of the signer
+	Validate(csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) error
+	// Sign issues a certificate, returning it followed by the chain of its issuers
+	Sign(ctx context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error)
+	// CA returns the PEM-encoded certificate of the issuing CA
+	CA() ([]byte, error)
+}
+
+var _ Signer = &sign.VaultSigner{}
+var _ Signer = &LocalSigner{}
+
+// NewSigner creates the signer described by the configuration, backed either by a Vault role or by a local CA.
+// The Vault and Kubernetes clients are only used by the corresponding backends and may be nil otherwise
+func NewSigner(ctx context.Context, vclient *vault.Client, kclient kubernetes.Interface, s config.SignerConfig) (Signer, error) {
+	return NewLimiters().NewSigner(ctx, vclient, kclient, s)
+}
+
+// NewSigner creates the signer described by the configuration, reusing the limits state of the previous signer
+// with the same name
+func (l *Limiters) NewSigner(ctx context.Context, vclient *vault.Client, kclient kubernetes.Interface, s config.SignerConfig) (Signer, error) {
+	signer, err := newSigner(ctx, vclient, kclient, s)
+	if err != nil || s.Limits == nil {
+		return signer, err
+	}
+
+	limits := Limits{QPS: s.Limits.QPS, Burst: s.Limits.Burst, MaxInFlight: s.Limits.MaxInFlight}
+	if cb := s.Limits.CircuitBreaker; cb != nil {
+		limits.FailureThreshold, limits.OpenDuration = cb.FailureThreshold, cb.OpenDuration.Duration
+	}
+	return l.Wrap(s.Name, signer, limits), nil
+}
+
+func newSigner(ctx context.Context, vclient *vault.Client, kclient kubernetes.Interface, s config.SignerConfig) (Signer, error) {
+	if s.Local == nil {
+		if vclient == nil {
+			return nil, fmt.Errorf("signer %s requires a Vault client", s.Name)
+		}
+		if s.Verbatim {
+			return sign.NewVerbatimSigner(vclient, s.Pki, s.Role)
+		}
+		return sign.NewSigner(vclient, s.Pki, s.Role)
+	}
+
+	if s.Local.SecretName != "" {
+		if kclient == nil {
+			return nil, fmt.Errorf("signer %s requires a Kubernetes client", s.Name)
+		}
+		return NewLocalSignerFromSecret(ctx, kclient, s.Local.SecretNamespace, s.Local.SecretName, s.SigningDuration.Duration)
+	}
+	return NewLocalSignerFromFiles(s.Local.CertFile, s.Local.KeyFile, s.SigningDuration.Duration)
+}
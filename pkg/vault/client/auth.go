@@ -3,6 +3,9 @@ package client
 import (
 	"context"
 	"fmt"
+	"os"
+	"reflect"
+	"strings"
 
 	vault "github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/api/auth/approle"
@@ -12,22 +15,27 @@ import (
 )
 
 type GlobalAuthConfig struct {
-	AuthType string `gcfg:"auth-type"`
+	AuthType string `gcfg:"auth-type" json:"authType"`
 }
 
 type AppRoleAuthConfig struct {
-	RoleId   string `gcfg:"role-id"`
-	SecretId string `gcfg:"secret-id"`
+	RoleId       string `gcfg:"role-id" json:"roleId,omitempty"`
+	RoleIdFile   string `gcfg:"role-id-file" json:"roleIdFile,omitempty"`
+	SecretId     string `gcfg:"secret-id" json:"secretId,omitempty"`
+	SecretIdFile string `gcfg:"secret-id-file" json:"secretIdFile,omitempty"`
+	SecretIdEnv  string `gcfg:"secret-id-env" json:"secretIdEnv,omitempty"`
+	// The secret-id is a response-wrapping token, unwrapped at login time
+	WrappedSecretId bool `gcfg:"wrapped-secret-id" json:"wrappedSecretId,omitempty"`
 }
 
 type KubernetesAuthConfig struct {
-	RoleName string `gcfg:"role-name"`
+	RoleName string `gcfg:"role-name" json:"roleName,omitempty"`
 }
 
 type AuthConfig struct {
-	Global     GlobalAuthConfig
-	AppRole    AppRoleAuthConfig
-	Kubernetes KubernetesAuthConfig
+	Global     GlobalAuthConfig     `json:"global"`
+	AppRole    AppRoleAuthConfig    `json:"appRole,omitempty"`
+	Kubernetes KubernetesAuthConfig `json:"kubernetes,omitempty"`
 }
 
 type Authenticator struct {
@@ -43,6 +51,15 @@ func NewAuthenticator(vaultAuthFilePath string) (*Authenticator, error) {
 	return &Authenticator{authConfig: cfg}, nil
 }
 
+func NewAuthenticatorFromConfig(cfg *AuthConfig) *Authenticator {
+	return &Authenticator{authConfig: cfg}
+}
+
+// Equal reports whether the authenticators log into Vault with the same configuration
+func (a *Authenticator) Equal(b *Authenticator) bool {
+	return reflect.DeepEqual(a.authConfig, b.authConfig)
+}
+
 func (a *Authenticator) Authenticate(ctx context.Context, vclient *vault.Client) (*vault.Secret, error) {
 	switch authType := a.authConfig.Global.AuthType; authType {
 	case "approle":
@@ -65,12 +82,29 @@ func (a *Authenticator) Authenticate(ctx context.Context, vclient *vault.Client)
 }
 
 func (a *Authenticator) approleAuthentication(ctx context.Context, vclient *vault.Client) (*vault.Secret, error) {
+	cfg := a.authConfig.AppRole
+
+	roleId := cfg.RoleId
+	if cfg.RoleIdFile != "" {
+		data, err := os.ReadFile(cfg.RoleIdFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault approle role-id file: %s", err)
+		}
+		roleId = strings.TrimSpace(string(data))
+	}
+
 	secretId := approle.SecretID{
-		FromString: a.authConfig.AppRole.SecretId,
+		FromString: cfg.SecretId,
+		FromFile:   cfg.SecretIdFile,
+		FromEnv:    cfg.SecretIdEnv,
+	}
+	var opts []approle.LoginOption
+	if cfg.WrappedSecretId {
+		opts = append(opts, approle.WithWrappingToken())
 	}
-	approleAuth, err := approle.NewAppRoleAuth(a.authConfig.AppRole.RoleId, &secretId)
+	approleAuth, err := approle.NewAppRoleAuth(roleId, &secretId, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Vault approle authenticator")
+		return nil, fmt.Errorf("failed to create Vault approle authenticator: %s", err)
 	}
 
 	secret, err := vclient.Auth().Login(ctx, approleAuth)
@@ -4,12 +4,23 @@ import (
 	"os"
 
 	"github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"gopkg.in/gcfg.v1"
 )
 
-func NewClient(address string) (*api.Client, error) {
+// NewClient creates a Vault client, whose requests are traced as child spans of the caller if traced is set
+func NewClient(address string, traced bool) (*api.Client, error) {
+	def := api.DefaultConfig()
+	if def.Error != nil {
+		return nil, def.Error
+	}
+	if traced {
+		def.HttpClient.Transport = otelhttp.NewTransport(def.HttpClient.Transport)
+	}
+
 	vclient, err := api.NewClient(&api.Config{
 		Address:    address,
+		HttpClient: def.HttpClient,
 		MaxRetries: 10,
 	})
 	if err != nil {
@@ -19,6 +30,11 @@ func NewClient(address string) (*api.Client, error) {
 	return vclient, nil
 }
 
+// LoadAuthConfig reads a gcfg authentication file
+func LoadAuthConfig(configFilePath string) (*AuthConfig, error) {
+	return initConfig(configFilePath)
+}
+
 func initConfig(configFilePath string) (*AuthConfig, error) {
 	config, err := os.Open(configFilePath)
 	defer func() { _ = config.Close() }()
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	vault "github.com/hashicorp/vault/api"
 
@@ -10,8 +11,11 @@ import (
 )
 
 type Watcher struct {
+	lock          sync.Mutex
 	authenticator *Authenticator
 	watcher       *vault.LifetimeWatcher
+	// the watcher has been replaced by Login, so the token must not be renewed through a new login
+	replaced bool
 }
 
 func NewWatcher(a *Authenticator, vclient *vault.Client, secret *vault.Secret) (*Watcher, error) {
@@ -26,14 +30,26 @@ func NewWatcher(a *Authenticator, vclient *vault.Client, secret *vault.Secret) (
 	}, nil
 }
 
+// Watch renews the token, logging into Vault again when it expires, until the context is done
 func (w *Watcher) Watch(ctx context.Context, vclient *vault.Client) {
 	for {
 		w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.lock.Lock()
+		replaced := w.replaced
+		w.replaced = false
+		w.lock.Unlock()
+		if replaced {
+			continue
+		}
 
 		logger := klog.FromContext(ctx)
 		logger.V(4).Info("retry logging into Vault")
 
-		secret, err := w.authenticator.Authenticate(ctx, vclient)
+		secret, err := w.Authenticator().Authenticate(ctx, vclient)
 		if err != nil {
 			klog.Exitf("error authenticating with Vault: %s", err)
 		}
@@ -43,18 +59,52 @@ func (w *Watcher) Watch(ctx context.Context, vclient *vault.Client) {
 			klog.Exitf("failed to create Vault lifetime watcher: %s", err)
 		}
 
+		w.lock.Lock()
 		w.watcher = watcher
+		w.lock.Unlock()
 	}
 }
 
+// Authenticator returns the authenticator used to log into Vault when the token expires
+func (w *Watcher) Authenticator() *Authenticator {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.authenticator
+}
+
+// Login logs into Vault with a new authenticator, e.g., after a credential rotation, and uses it for the next
+// logins only if it succeeds. The token of the client is replaced, and the new one is renewed from now on
+func (w *Watcher) Login(ctx context.Context, vclient *vault.Client, a *Authenticator) error {
+	secret, err := a.Authenticate(ctx, vclient)
+	if err != nil {
+		return err
+	}
+	watcher, err := lifetimeWatcher(vclient, secret)
+	if err != nil {
+		return fmt.Errorf("failed to create Vault lifetime watcher: %s", err)
+	}
+
+	w.lock.Lock()
+	previous := w.watcher
+	w.authenticator = a
+	w.watcher = watcher
+	w.replaced = true
+	w.lock.Unlock()
+	previous.Stop()
+	return nil
+}
+
 func (w *Watcher) watch(ctx context.Context) {
-	go w.watcher.Start()
-	defer w.watcher.Stop()
+	w.lock.Lock()
+	watcher := w.watcher
+	w.lock.Unlock()
+	go watcher.Start()
+	defer watcher.Stop()
 
 	logger := klog.FromContext(ctx)
 	for {
 		select {
-		case err := <-w.watcher.DoneCh():
+		case err := <-watcher.DoneCh():
 			if err != nil {
 				logger.V(4).Error(err, "failed to renew Vault token")
 			} else {
@@ -62,8 +112,11 @@ func (w *Watcher) watch(ctx context.Context) {
 			}
 			return
 
-		case _ = <-w.watcher.RenewCh():
+		case _ = <-watcher.RenewCh():
 			logger.V(4).Info("succesfully renewed Vault token")
+		case <-ctx.Done():
+			logger.V(4).Info("stopping Vault token renewal")
+			return
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package sign
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -10,7 +11,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/alpha-unito/k8s-vault-signer/pkg/tracing"
 	vault "github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"k8s.io/klog/v2"
 )
@@ -23,6 +27,9 @@ type VaultSigner struct {
 	keyUsage     x509.KeyUsage
 	extKeyUsages []x509.ExtKeyUsage
 	maxTTL       time.Duration
+
+	// verbatim signers preserve the requested subject and SANs through the sign-verbatim endpoint
+	verbatim bool
 }
 
 func NewSigner(vclient *vault.Client, pki string, role string) (*VaultSigner, error) {
@@ -32,6 +39,9 @@ func NewSigner(vclient *vault.Client, pki string, role string) (*VaultSigner, er
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve %s Vault role for pki %s: %v", role, pki, err)
 	}
+	if secret == nil {
+		return nil, fmt.Errorf("unable to retrieve %s Vault role for pki %s: role not found", role, pki)
+	}
 
 	keyUsage, err := keyUsageFromSecret(secret)
 	if err != nil {
@@ -71,31 +81,86 @@ func NewSigner(vclient *vault.Client, pki string, role string) (*VaultSigner, er
 	}, nil
 }
 
-func (s *VaultSigner) Sign(csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) (*x509.Certificate, error) {
+// NewVerbatimSigner creates a signer that issues certificates through the <pki>/sign-verbatim/<role> endpoint,
+// so that the role cannot override the subject and the SANs of the request
+func NewVerbatimSigner(vclient *vault.Client, pki string, role string) (*VaultSigner, error) {
+	s, err := NewSigner(vclient, pki, role)
+	if err != nil {
+		return nil, err
+	}
+	s.verbatim = true
+	return s, nil
+}
+
+// Usages returns the names of the key usages and extended key usages allowed by the Vault role
+func (s *VaultSigner) Usages() []string {
+	var usages []string
+	for name, usage := range keyUsageDict {
+		if s.keyUsage&usage != 0 {
+			usages = append(usages, name)
+		}
+	}
+	for name, usage := range extKeyUsageDict {
+		if slices.Contains(s.extKeyUsages, usage) {
+			usages = append(usages, name)
+		}
+	}
+	sort.Strings(usages)
+	return usages
+}
+
+func (s *VaultSigner) MaxTTL() time.Duration {
+	return s.maxTTL
+}
 
+// Validate checks the requested usages and ttl against the constraints of the Vault role. The requested usages
+// must be allowed by the role, while the usages actually issued through the sign endpoint, which are determined
+// by the role, are checked against the request after issuance
+func (s *VaultSigner) Validate(csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) error {
 	if usage|s.keyUsage != s.keyUsage {
-		return nil, fmt.Errorf("unable to sign csr with Vault for %s: forbidden key usage", csr.Subject.CommonName)
+		return fmt.Errorf("unable to sign csr with Vault for %s: forbidden key usage", csr.Subject.CommonName)
 	}
 
 	for _, extUsage := range extUsages {
 		if ok := slices.Contains(s.extKeyUsages, extUsage); !ok {
-			return nil, fmt.Errorf("unable to sign csr with Vault for %s: forbidden ext key usage", csr.Subject.CommonName)
+			return fmt.Errorf("unable to sign csr with Vault for %s: forbidden ext key usage", csr.Subject.CommonName)
 		}
 	}
 
 	if ttl > s.maxTTL {
-		return nil, fmt.Errorf("unable to sign csr with Vault for %s: ttl (%s) exceeds max ttl (%s)", csr.Subject.CommonName, ttl, s.maxTTL)
+		return fmt.Errorf("unable to sign csr with Vault for %s: ttl (%s) exceeds max ttl (%s)", csr.Subject.CommonName, ttl, s.maxTTL)
 	}
 
-	secret, err := s.vclient.Logical().Write(
-		fmt.Sprintf("%s/sign/%s", s.pki, s.role),
-		map[string]interface{}{
-			"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
-			"ttl": ttl.String(),
-		},
-	)
+	return nil
+}
+
+// Sign signs the request with the Vault role, returning the issued certificate followed by the CA chain
+func (s *VaultSigner) Sign(ctx context.Context, csr *x509.CertificateRequest, usage x509.KeyUsage, extUsages []x509.ExtKeyUsage, ttl time.Duration) ([]*x509.Certificate, error) {
+	if err := s.Validate(csr, usage, extUsages, ttl); err != nil {
+		return nil, err
+	}
+
+	endpoint := "sign"
+	data := map[string]interface{}{
+		"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+		"ttl": ttl.String(),
+	}
+	for k, v := range subjectAltNames(csr) {
+		data[k] = v
+	}
+	if s.verbatim {
+		endpoint = "sign-verbatim"
+		data["key_usage"] = keyUsageNames(usage)
+		data["ext_key_usage"] = extKeyUsageNames(extUsages)
+	}
+
+	path := fmt.Sprintf("%s/%s/%s", s.pki, endpoint, s.role)
+	ctx, span := tracing.Tracer().Start(ctx, "Vault "+endpoint, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("vault.path", path)))
+	secret, err := s.vclient.Logical().WriteWithContext(ctx, path, data)
+	tracing.End(span, err)
 	if err != nil {
-		return nil, fmt.Errorf("unable to sign csr with Vault for %s: %v", csr.Subject.CommonName, err)
+		return nil, fmt.Errorf("unable to sign csr with Vault for %s: %w", csr.Subject.CommonName, err)
 	}
 
 	block, _ := pem.Decode([]byte(secret.Data["certificate"].(string)))
@@ -107,15 +172,47 @@ func (s *VaultSigner) Sign(csr *x509.CertificateRequest, usage x509.KeyUsage, ex
 		return nil, fmt.Errorf("invalid certificate generated by Vault for %s: %v", csr.Subject.CommonName, err)
 	}
 
-	return cert, nil
+	chain := []*x509.Certificate{cert}
+	caChain, _ := secret.Data["ca_chain"].([]interface{})
+	for _, c := range caChain {
+		pemCert, _ := c.(string)
+		block, _ := pem.Decode([]byte(pemCert))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid CA chain returned by Vault for %s: PEM block type must be CERTIFICATE", csr.Subject.CommonName)
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA chain returned by Vault for %s: %v", csr.Subject.CommonName, err)
+		}
+		chain = append(chain, ca)
+	}
+
+	return chain, nil
+}
+
+func (s *VaultSigner) CA() ([]byte, error) {
+	secret, err := s.vclient.Logical().Read(fmt.Sprintf("%s/cert/ca", s.pki))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve CA certificate for pki %s: %v", s.pki, err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("unable to retrieve CA certificate for pki %s: empty response", s.pki)
+	}
+
+	ca, ok := secret.Data["certificate"].(string)
+	if !ok || ca == "" {
+		return nil, fmt.Errorf("invalid CA certificate returned by Vault for pki %s", s.pki)
+	}
+
+	return []byte(ca), nil
 }
 
 var keyUsageDict = map[string]x509.KeyUsage{
 	"digitalsignature":  x509.KeyUsageDigitalSignature,
 	"contentcommitment": x509.KeyUsageContentCommitment,
 	"keyencipherment":   x509.KeyUsageKeyEncipherment,
-	"dataencipherment":  x509.KeyUsageKeyAgreement,
-	"keyagreement":      x509.KeyUsageDataEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
 	"certsign":          x509.KeyUsageCertSign,
 	"crlsign":           x509.KeyUsageCRLSign,
 	"encipheronly":      x509.KeyUsageEncipherOnly,
@@ -135,6 +232,83 @@ var extKeyUsageDict = map[string]x509.ExtKeyUsage{
 	"oscpsigning":     x509.ExtKeyUsageOCSPSigning,
 }
 
+// subjectAltNames returns the alt_names, ip_sans, and uri_sans parameters matching the SANs of the request,
+// which the sign endpoint would otherwise ignore in favour of the role defaults
+func subjectAltNames(csr *x509.CertificateRequest) map[string]interface{} {
+	params := make(map[string]interface{})
+	altNames := append(append([]string{}, csr.DNSNames...), csr.EmailAddresses...)
+	if len(altNames) > 0 {
+		params["alt_names"] = strings.Join(altNames, ",")
+	}
+	if len(csr.IPAddresses) > 0 {
+		ips := make([]string, 0, len(csr.IPAddresses))
+		for _, ip := range csr.IPAddresses {
+			ips = append(ips, ip.String())
+		}
+		params["ip_sans"] = strings.Join(ips, ",")
+	}
+	if len(csr.URIs) > 0 {
+		uris := make([]string, 0, len(csr.URIs))
+		for _, uri := range csr.URIs {
+			uris = append(uris, uri.String())
+		}
+		params["uri_sans"] = strings.Join(uris, ",")
+	}
+	return params
+}
+
+// vaultKeyUsageNames and vaultExtKeyUsageNames are the names accepted by the key_usage and ext_key_usage
+// parameters of the Vault PKI endpoints
+var vaultKeyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "DigitalSignature"},
+	{x509.KeyUsageContentCommitment, "ContentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "KeyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "DataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "KeyAgreement"},
+	{x509.KeyUsageCertSign, "CertSign"},
+	{x509.KeyUsageCRLSign, "CRLSign"},
+	{x509.KeyUsageEncipherOnly, "EncipherOnly"},
+	{x509.KeyUsageDecipherOnly, "DecipherOnly"},
+}
+
+var vaultExtKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                        "Any",
+	x509.ExtKeyUsageServerAuth:                 "ServerAuth",
+	x509.ExtKeyUsageClientAuth:                 "ClientAuth",
+	x509.ExtKeyUsageCodeSigning:                "CodeSigning",
+	x509.ExtKeyUsageEmailProtection:            "EmailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:             "IPSECEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:                "IPSECTunnel",
+	x509.ExtKeyUsageIPSECUser:                  "IPSECUser",
+	x509.ExtKeyUsageTimeStamping:               "TimeStamping",
+	x509.ExtKeyUsageOCSPSigning:                "OCSPSigning",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto: "MicrosoftServerGatedCrypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:  "NetscapeServerGatedCrypto",
+}
+
+func keyUsageNames(usage x509.KeyUsage) []string {
+	names := []string{}
+	for _, u := range vaultKeyUsageNames {
+		if usage&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+func extKeyUsageNames(extUsages []x509.ExtKeyUsage) []string {
+	names := []string{}
+	for _, u := range extUsages {
+		if name, ok := vaultExtKeyUsageNames[u]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func keyUsageFromSecret(secret *vault.Secret) (x509.KeyUsage, error) {
 	usages := secret.Data["key_usage"].([]interface{})
 	var keyUsage x509.KeyUsage